@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+	"github.com/theTardigrade/golang-epubGenerator/epub"
+)
+
+// outputStdout is the output path that writes the book to standard output.
+const outputStdout = "-"
+
+var buildCommand = &command{
+	name:        "build",
+	description: "generate an EPUB book from an options file",
+}
+
+// buildReport is printed by the build command when the json format is used.
+type buildReport struct {
+	Output             string                   `json:"output,omitempty"`
+	Error              string                   `json:"error,omitempty"`
+	Diagnostics        epub.Diagnostics         `json:"diagnostics"`
+	ErrorCount         int                      `json:"error_count"`
+	WarningCount       int                      `json:"warning_count"`
+	ImageOptimizations []epub.ImageOptimization `json:"image_optimizations"`
+	FontSubsets        []epub.FontSubset        `json:"font_subsets"`
+}
+
+func init() {
+	buildCommand.run = runBuild
+}
+
+func runBuild(args []string) (err error) {
+	flags := newFlagSet(buildCommand)
+	report := addReportFlags(flags)
+
+	var overrides setFlag
+
+	configPath := flags.String("config", epub.OptionsFileName, "`path` of the book's options file")
+	outputPath := flags.String("output", "", "`path` of the generated book, or - for standard output (default: the output_path option, or a name derived from the title next to the options file)")
+	force := flags.Bool("force", false, "overwrite the book if it already exists")
+	flags.Var(&overrides, "set", "override an option, given as `key=value`; may be repeated")
+
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+
+	if err = report.validate(); err != nil {
+		return
+	}
+
+	if flags.NArg() > 0 {
+		return usageError{message: "unexpected argument: " + flags.Arg(0)}
+	}
+
+	options, err := loadOptions(*configPath, overrides)
+	if err != nil {
+		if report.format == formatJSON {
+			result := buildReport{
+				Error:              err.Error(),
+				Diagnostics:        epub.Diagnostics{},
+				ImageOptimizations: []epub.ImageOptimization{},
+				FontSubsets:        []epub.FontSubset{},
+			}
+
+			if errors.As(err, &result.Diagnostics) {
+				result.ErrorCount = len(result.Diagnostics)
+			}
+
+			printJSON(os.Stdout, result)
+		}
+
+		return
+	}
+
+	book := epub.NewBook(options)
+
+	if *outputPath == "" {
+		*outputPath = book.OutputPath()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// the report is kept off standard output when the book is written there
+	reportOutput := os.Stdout
+
+	if *outputPath == outputStdout {
+		reportOutput = os.Stderr
+		err = book.Build(ctx, os.Stdout)
+	} else {
+		err = book.WriteFile(ctx, *outputPath, *force)
+	}
+
+	if errors.Is(err, fs.ErrExist) {
+		err = errors.New(err.Error() + " (use -force to overwrite)")
+	}
+
+	diagnostics := book.Diagnostics()
+
+	// the diagnostics are reported in full below, so only summarize them
+	var diagnosticsErr epub.Diagnostics
+
+	if errors.As(err, &diagnosticsErr) {
+		err = errors.New(diagnosticsSummary(diagnostics))
+	}
+
+	if report.format == formatJSON {
+		result := buildReport{
+			Diagnostics:        append(epub.Diagnostics{}, diagnostics...),
+			ErrorCount:         len(diagnostics.Errors()),
+			WarningCount:       len(diagnostics.Warnings()),
+			ImageOptimizations: append([]epub.ImageOptimization{}, book.ImageOptimizations()...),
+			FontSubsets:        append([]epub.FontSubset{}, book.FontSubsets()...),
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+		} else if *outputPath != outputStdout {
+			result.Output = *outputPath
+		}
+
+		if jsonErr := printJSON(reportOutput, result); jsonErr != nil && err == nil {
+			err = jsonErr
+		}
+
+		return
+	}
+
+	if report.verbosity() >= verbosityVerbose {
+		printBuildDetails(book)
+	}
+
+	printDiagnostics(report, diagnostics)
+
+	if report.verbosity() >= verbosityNormal && err == nil {
+		if len(diagnostics) > 0 {
+			fmt.Fprintln(os.Stderr, diagnosticsSummary(diagnostics))
+		}
+
+		if *outputPath != outputStdout {
+			fmt.Fprintln(reportOutput, "wrote "+*outputPath)
+		}
+	}
+
+	return
+}
+
+// printDiagnostics prints the diagnostics to standard error, leaving out
+// warnings when the report is quiet.
+func printDiagnostics(report *reportFlags, diagnostics epub.Diagnostics) {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == epub.SeverityError || report.verbosity() >= verbosityNormal {
+			fmt.Fprintln(os.Stderr, diagnostic)
+		}
+	}
+}
+
+// diagnosticsSummary counts the errors and warnings in diagnostics,
+// as in "2 errors and 1 warning".
+func diagnosticsSummary(diagnostics epub.Diagnostics) string {
+	errorCount := len(diagnostics.Errors())
+	warningCount := len(diagnostics.Warnings())
+
+	switch {
+	case errorCount == 0:
+		return pluralize(warningCount, "warning")
+	case warningCount == 0:
+		return pluralize(errorCount, "error")
+	}
+
+	return pluralize(errorCount, "error") + " and " + pluralize(warningCount, "warning")
+}
+
+func pluralize(n int, noun string) string {
+	if n != 1 {
+		noun += "s"
+	}
+
+	return strconv.Itoa(n) + " " + noun
+}
+
+func printBuildDetails(book *epub.Book) {
+	for _, optimization := range book.ImageOptimizations() {
+		var cached string
+
+		if optimization.Cached {
+			cached = " (cached)"
+		}
+
+		fmt.Fprintf(os.Stderr, "optimized %s: %s -> %s%s\n", optimization.Path,
+			humanize.Bytes(uint64(optimization.OriginalSize)), humanize.Bytes(uint64(optimization.OptimizedSize)), cached)
+	}
+
+	for _, subset := range book.FontSubsets() {
+		var cached string
+
+		if subset.Cached {
+			cached = " (cached)"
+		}
+
+		fmt.Fprintf(os.Stderr, "subsetted %s: %s -> %s%s\n", subset.Path,
+			humanize.Bytes(uint64(subset.OriginalSize)), humanize.Bytes(uint64(subset.SubsetSize)), cached)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/theTardigrade/golang-epubGenerator/epub"
+)
+
+var initCommand = &command{
+	name:        "init",
+	arguments:   "[directory]",
+	description: "create a starter options file, text and stylesheet for a new book",
+}
+
+const (
+	initTextFileName   = "text.md"
+	initStylesFileName = "styles.css"
+)
+
+const initText = `# Chapter One
+
+The first chapter of the book begins here.
+
+# Chapter Two
+
+The second chapter of the book begins here.
+`
+
+const initStyles = `body {
+	line-height: 1.5;
+}
+
+h1 {
+	text-align: center;
+}
+`
+
+// initOptions holds the options that the init command sets, so that the
+// options file it creates is not cluttered with every other option.
+type initOptions struct {
+	EPUBVersion          int    `json:"epub_version"`
+	Title                string `json:"title"`
+	Author               string `json:"author"`
+	Language             string `json:"language"`
+	IncludeContentsPage  bool   `json:"include_contents_page"`
+	IncludeCopyrightPage bool   `json:"include_copyright_page"`
+	ShouldGenerateCover  bool   `json:"should_generate_cover"`
+	ShouldSplitTextFiles bool   `json:"should_split_text_files"`
+	Paths                struct {
+		Text   string `json:"text"`
+		Styles string `json:"styles"`
+	} `json:"paths"`
+}
+
+func init() {
+	initCommand.run = runInit
+}
+
+func runInit(args []string) (err error) {
+	flags := newFlagSet(initCommand)
+	report := addReportFlags(flags)
+
+	title := flags.String("title", "Untitled", "`title` of the book")
+	author := flags.String("author", "", "`name` of the book's author")
+	language := flags.String("language", "en", "language `tag` of the book")
+	force := flags.Bool("force", false, "overwrite existing files")
+
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+
+	if err = report.validate(); err != nil {
+		return
+	}
+
+	if flags.NArg() > 1 {
+		return usageError{message: "unexpected argument: " + flags.Arg(1)}
+	}
+
+	dir := flags.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	options := initOptions{
+		EPUBVersion:          3,
+		Title:                *title,
+		Author:               *author,
+		Language:             *language,
+		IncludeContentsPage:  true,
+		IncludeCopyrightPage: true,
+		ShouldGenerateCover:  true,
+		ShouldSplitTextFiles: true,
+	}
+
+	options.Paths.Text = initTextFileName
+	options.Paths.Styles = initStylesFileName
+
+	var optionsContent bytes.Buffer
+
+	if err = printJSON(&optionsContent, options); err != nil {
+		return
+	}
+
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{epub.OptionsFileName, optionsContent.Bytes()},
+		{initTextFileName, []byte(initText)},
+		{initStylesFileName, []byte(initStyles)},
+	}
+
+	if !*force {
+		for _, file := range files {
+			path := filepath.Join(dir, file.name)
+
+			if _, err = os.Stat(path); err == nil {
+				return errors.New("file already exists: " + path + " (use -force to overwrite)")
+			} else if !os.IsNotExist(err) {
+				return
+			}
+		}
+
+		err = nil
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+
+		if err = os.WriteFile(path, file.content, 0644); err != nil {
+			return
+		}
+
+		if report.verbosity() >= verbosityNormal && report.format == formatText {
+			fmt.Println("created " + path)
+		}
+	}
+
+	if report.format == formatJSON {
+		paths := make([]string, 0, len(files))
+
+		for _, file := range files {
+			paths = append(paths, filepath.Join(dir, file.name))
+		}
+
+		return printJSON(os.Stdout, struct {
+			Created []string `json:"created"`
+		}{paths})
+	}
+
+	return
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/theTardigrade/golang-epubGenerator/epub"
+)
+
+var inspectCommand = &command{
+	name:        "inspect",
+	arguments:   "book.epub",
+	description: "print the metadata, manifest and spine of an EPUB book",
+}
+
+func init() {
+	inspectCommand.run = runInspect
+}
+
+func runInspect(args []string) (err error) {
+	flags := newFlagSet(inspectCommand)
+	report := addReportFlags(flags)
+
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+
+	if err = report.validate(); err != nil {
+		return
+	}
+
+	if flags.NArg() != 1 {
+		return usageError{message: "expected exactly one book"}
+	}
+
+	inspection, err := epub.Inspect(flags.Arg(0))
+	if err != nil {
+		return
+	}
+
+	if report.format == formatJSON {
+		return printJSON(os.Stdout, inspection)
+	}
+
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"title", inspection.Title},
+		{"language", inspection.Language},
+		{"creators", strings.Join(inspection.Creators, ", ")},
+		{"publisher", inspection.Publisher},
+		{"date", inspection.Date},
+		{"modified", inspection.Modified},
+		{"identifiers", strings.Join(inspection.Identifiers, ", ")},
+		{"version", inspection.Version},
+	}
+
+	for _, field := range fields {
+		if field.value != "" {
+			fmt.Printf("%-12s %s\n", field.name+":", field.value)
+		}
+	}
+
+	if report.verbosity() < verbosityNormal {
+		return
+	}
+
+	fmt.Println("\nmanifest:")
+
+	var total int64
+
+	for _, item := range inspection.Manifest {
+		total += item.Size
+
+		if report.verbosity() >= verbosityVerbose {
+			fmt.Printf("  %-40s %-28s %8s %8s\n", item.Path, item.MediaType,
+				humanize.Bytes(uint64(item.Size)), humanize.Bytes(uint64(item.CompressedSize)))
+		} else {
+			fmt.Printf("  %-40s %-28s %8s\n", item.Path, item.MediaType, humanize.Bytes(uint64(item.Size)))
+		}
+	}
+
+	fmt.Printf("  %d files, %s\n", len(inspection.Manifest), humanize.Bytes(uint64(total)))
+
+	fmt.Println("\nspine:")
+
+	for i, path := range inspection.Spine {
+		fmt.Printf("  %3d %s\n", i+1, path)
+	}
+
+	return
+}
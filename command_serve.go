@@ -0,0 +1,433 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theTardigrade/golang-epubGenerator/epub"
+)
+
+var serveCommand = &command{
+	name:        "serve",
+	description: "preview an EPUB book in a browser, reloading it whenever its sources change",
+}
+
+const (
+	serveDefaultAddress = "localhost:8080"
+)
+
+// previewServer serves the pages of the most recently built book, each
+// wrapped in a page that adds the book's table of contents and links to
+// the previous and next pages of its spine.
+type previewServer struct {
+	mutex      sync.RWMutex
+	title      string
+	files      map[string][]byte
+	mediaTypes map[string]string
+	spine      []string
+	headings   []epub.Heading
+	problems   []string
+	// reloaded is closed, and replaced, whenever the book is rebuilt
+	reloaded chan struct{}
+}
+
+// previewPage is the data of the page that wraps a page of the book.
+type previewPage struct {
+	Title    string
+	Path     string
+	Position int
+	Count    int
+	Previous string
+	Next     string
+	Headings []epub.Heading
+	Problems []string
+}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Path}} - {{.Title}}</title>
+<style>
+body { margin: 0; display: flex; height: 100vh; font-family: sans-serif; }
+nav { width: 16rem; overflow: auto; padding: 1rem; border-right: 1px solid #ccc; box-sizing: border-box; font-size: 0.9rem; }
+nav h1 { font-size: 1rem; }
+nav ul { margin: 0; padding-left: 1rem; list-style: none; }
+nav > ul { padding-left: 0; }
+nav li { margin: 0.25rem 0; }
+nav a { color: inherit; text-decoration: none; }
+nav a:hover { text-decoration: underline; }
+main { flex: 1; display: flex; flex-direction: column; }
+header { display: flex; justify-content: space-between; padding: 0.5rem 1rem; border-bottom: 1px solid #ccc; }
+.problems { margin: 0; padding: 0.5rem 1rem 0.5rem 2rem; background: #fee; color: #900; font-size: 0.85rem; }
+iframe { flex: 1; width: 100%; border: 0; }
+</style>
+</head>
+<body>
+<nav>
+<h1>{{.Title}}</h1>
+{{template "headings" .Headings}}
+</nav>
+<main>
+{{if .Problems}}<ul class="problems">{{range .Problems}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<header>
+{{if .Previous}}<a href="/view/{{.Previous}}">&larr; previous</a>{{else}}<span></span>{{end}}
+<span>{{.Path}}{{if .Position}} ({{.Position}} of {{.Count}}){{end}}</span>
+{{if .Next}}<a href="/view/{{.Next}}">next &rarr;</a>{{else}}<span></span>{{end}}
+</header>
+<iframe id="page" src="/book/{{.Path}}"></iframe>
+</main>
+<script>
+var page = document.getElementById("page");
+
+if (location.hash) {
+	page.src = "/book/{{.Path}}" + location.hash;
+}
+
+// follow links within the book, such as those on the contents page
+page.addEventListener("load", function () {
+	var path = decodeURIComponent(page.contentWindow.location.pathname.replace(/^\/book\//, ""));
+
+	if (path !== {{.Path}}) {
+		location.replace("/view/" + path + page.contentWindow.location.hash);
+	}
+});
+
+new EventSource("/events").addEventListener("reload", function () {
+	location.reload();
+});
+</script>
+</body>
+</html>
+{{define "headings"}}{{if .}}<ul>{{range .}}<li><a href="/view/{{.Href}}">{{.Text}}</a>{{template "headings" .Children}}</li>{{end}}</ul>{{end}}{{end}}`))
+
+func init() {
+	serveCommand.run = runServe
+}
+
+func runServe(args []string) (err error) {
+	flags := newFlagSet(serveCommand)
+
+	session := &watchSession{
+		report: addReportFlags(flags),
+	}
+
+	flags.StringVar(&session.configPath, "config", epub.OptionsFileName, "`path` of the book's options file")
+	flags.Var(&session.overrides, "set", "override an option, given as `key=value`; may be repeated")
+	address := flags.String("address", serveDefaultAddress, "`host:port` on which to serve the preview")
+	interval := flags.Duration("interval", watchDefaultInterval, "how often to check the sources for changes")
+
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+
+	if err = session.report.validate(); err != nil {
+		return
+	}
+
+	if flags.NArg() > 0 {
+		return usageError{message: "unexpected argument: " + flags.Arg(0)}
+	}
+
+	if *interval <= 0 {
+		return usageError{message: "interval must be positive"}
+	}
+
+	server := &previewServer{
+		files:    make(map[string][]byte),
+		reloaded: make(chan struct{}),
+	}
+
+	listener, err := net.Listen("tcp", *address)
+	if err != nil {
+		return
+	}
+
+	session.write = func(ctx context.Context, book *epub.Book) (output string, digests map[string]string, err error) {
+		var buffer bytes.Buffer
+
+		if err = book.Build(ctx, &buffer); err != nil {
+			server.fail(book, err)
+			return
+		}
+
+		r, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+		if err != nil {
+			return
+		}
+
+		if err = server.update(r, book); err != nil {
+			return
+		}
+
+		digests, err = archiveDigests(r)
+
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err = session.build(ctx, nil); err != nil {
+		return
+	}
+
+	httpServer := &http.Server{
+		Handler: server.handler(),
+		// ends the event streams of open pages when the preview is stopped
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	if session.report.format == formatText {
+		fmt.Println("serving preview at http://" + listener.Addr().String() + "/")
+	}
+
+	serveErr := make(chan error, 1)
+
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+
+	go session.watch(ctx, *interval)
+
+	select {
+	case err = <-serveErr:
+		return
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = httpServer.Shutdown(shutdownCtx); err != nil {
+		return
+	}
+
+	return
+}
+
+// update replaces the book being served with the one in archive r,
+// built from book, and reloads the pages open in browsers.
+func (server *previewServer) update(r *zip.Reader, book *epub.Book) (err error) {
+	inspection, err := epub.InspectReader(r)
+	if err != nil {
+		return
+	}
+
+	files := make(map[string][]byte, len(r.File))
+
+	for _, f := range r.File {
+		var rc io.ReadCloser
+
+		if rc, err = f.Open(); err != nil {
+			return
+		}
+
+		files[f.Name], err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	mediaTypes := make(map[string]string, len(inspection.Manifest))
+
+	for _, item := range inspection.Manifest {
+		mediaTypes[item.Path] = item.MediaType
+	}
+
+	var problems []string
+
+	for _, diagnostic := range book.Diagnostics() {
+		problems = append(problems, diagnostic.String())
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.title = inspection.Title
+	server.files = files
+	server.mediaTypes = mediaTypes
+	server.spine = inspection.Spine
+	server.headings = book.Headings()
+	server.problems = problems
+
+	server.reload()
+
+	return
+}
+
+// fail reports a failed build on the pages open in browsers, which continue
+// to show the book as it was last built.
+func (server *previewServer) fail(book *epub.Book, err error) {
+	var problems []string
+
+	var diagnostics epub.Diagnostics
+
+	if errors.As(err, &diagnostics) {
+		for _, diagnostic := range book.Diagnostics() {
+			problems = append(problems, diagnostic.String())
+		}
+	} else {
+		problems = append(problems, err.Error())
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.problems = problems
+
+	server.reload()
+}
+
+// reload must be called with the mutex locked.
+func (server *previewServer) reload() {
+	close(server.reloaded)
+	server.reloaded = make(chan struct{})
+}
+
+func (server *previewServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", server.serveRoot)
+	mux.HandleFunc("/view/", server.serveView)
+	mux.HandleFunc("/book/", server.serveBook)
+	mux.HandleFunc("/events", server.serveEvents)
+
+	return mux
+}
+
+func (server *previewServer) serveRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	if len(server.spine) == 0 {
+		http.Error(w, "the book has not been built", http.StatusServiceUnavailable)
+		return
+	}
+
+	http.Redirect(w, r, "/view/"+server.spine[0], http.StatusFound)
+}
+
+func (server *previewServer) serveView(w http.ResponseWriter, r *http.Request) {
+	pagePath := strings.TrimPrefix(r.URL.Path, "/view/")
+
+	server.mutex.RLock()
+
+	if _, ok := server.files[pagePath]; !ok {
+		server.mutex.RUnlock()
+		http.NotFound(w, r)
+
+		return
+	}
+
+	page := previewPage{
+		Title:    server.title,
+		Path:     pagePath,
+		Count:    len(server.spine),
+		Headings: server.headings,
+		Problems: server.problems,
+	}
+
+	for i, spinePath := range server.spine {
+		if spinePath != pagePath {
+			continue
+		}
+
+		page.Position = i + 1
+
+		if i > 0 {
+			page.Previous = server.spine[i-1]
+		}
+
+		if i+1 < len(server.spine) {
+			page.Next = server.spine[i+1]
+		}
+
+		break
+	}
+
+	server.mutex.RUnlock()
+
+	var buffer bytes.Buffer
+
+	if err := previewTemplate.Execute(&buffer, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buffer.Bytes())
+}
+
+func (server *previewServer) serveBook(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimPrefix(r.URL.Path, "/book/")
+
+	server.mutex.RLock()
+	content, ok := server.files[filePath]
+	mediaType := server.mediaTypes[filePath]
+	server.mutex.RUnlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if mediaType == "" {
+		mediaType = mime.TypeByExtension(path.Ext(filePath))
+	}
+
+	if mediaType != "" {
+		w.Header().Set("Content-Type", mediaType)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(content)
+}
+
+// serveEvents streams a reload event to a page when the book is rebuilt.
+func (server *previewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	server.mutex.RLock()
+	reloaded := server.reloaded
+	server.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	select {
+	case <-r.Context().Done():
+	case <-reloaded:
+		fmt.Fprint(w, "event: reload\ndata:\n\n")
+		flusher.Flush()
+	}
+}
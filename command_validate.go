@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/theTardigrade/golang-epubGenerator/epub"
+)
+
+var validateCommand = &command{
+	name:        "validate",
+	arguments:   "book.epub...",
+	description: "check EPUB books for structural problems",
+}
+
+// validateReport is printed for each book by the validate command
+// when the json format is used.
+type validateReport struct {
+	Path     string                   `json:"path"`
+	Valid    bool                     `json:"valid"`
+	Error    string                   `json:"error,omitempty"`
+	Problems []epub.ValidationProblem `json:"problems"`
+}
+
+func init() {
+	validateCommand.run = runValidate
+}
+
+func runValidate(args []string) (err error) {
+	flags := newFlagSet(validateCommand)
+	report := addReportFlags(flags)
+
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+
+	if err = report.validate(); err != nil {
+		return
+	}
+
+	if flags.NArg() == 0 {
+		return usageError{message: "no books given"}
+	}
+
+	reports := make([]validateReport, 0, flags.NArg())
+	valid := true
+
+	for _, path := range flags.Args() {
+		result := validateReport{
+			Path: path,
+		}
+
+		problems, validateErr := epub.Validate(path)
+		if validateErr != nil {
+			result.Error = validateErr.Error()
+		} else {
+			result.Problems = problems
+			result.Valid = len(problems) == 0
+		}
+
+		if !result.Valid {
+			valid = false
+		}
+
+		if result.Problems == nil {
+			result.Problems = []epub.ValidationProblem{}
+		}
+
+		reports = append(reports, result)
+	}
+
+	if report.format == formatJSON {
+		if err = printJSON(os.Stdout, reports); err != nil {
+			return
+		}
+	} else {
+		for _, result := range reports {
+			if result.Error != "" {
+				fmt.Fprintln(os.Stderr, result.Path+": "+result.Error)
+			}
+
+			for _, problem := range result.Problems {
+				fmt.Println(result.Path + ": " + problem.String())
+			}
+
+			if result.Valid && report.verbosity() >= verbosityNormal {
+				fmt.Println(result.Path + ": valid")
+			}
+		}
+	}
+
+	if !valid {
+		return errors.New("validation failed")
+	}
+
+	return
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theTardigrade/golang-epubGenerator/epub"
+	hash "github.com/theTardigrade/golang-hash"
+)
+
+var watchCommand = &command{
+	name:        "watch",
+	description: "build an EPUB book, then rebuild it, overwriting it, whenever its sources change",
+}
+
+const (
+	watchDefaultInterval = 500 * time.Millisecond
+)
+
+var (
+	// the modification date in the package document changes with every
+	// build, so is left out when comparing builds
+	watchModifiedRegexp = regexp.MustCompile(`<meta property="dcterms:modified">[^<]*</meta>`)
+)
+
+// watchReport is printed by the watch command after each build
+// when the json format is used.
+type watchReport struct {
+	ChangedSources []string         `json:"changed_sources"`
+	Output         string           `json:"output,omitempty"`
+	Error          string           `json:"error,omitempty"`
+	Diagnostics    epub.Diagnostics `json:"diagnostics"`
+	Added          []string         `json:"added"`
+	Changed        []string         `json:"changed"`
+	Removed        []string         `json:"removed"`
+	Duration       int64            `json:"duration_ms"`
+}
+
+// watchSession builds a book each time its sources change.
+type watchSession struct {
+	report     *reportFlags
+	configPath string
+	overrides  setFlag
+	// write builds the book and writes it out, returning the path to which
+	// it was written, if any, along with the digests of the files in its
+	// archive
+	write func(ctx context.Context, book *epub.Book) (output string, digests map[string]string, err error)
+	// book is kept from one build to the next, so that the results of
+	// building sources that have not changed can be reused
+	book    *epub.Book
+	sources []string
+	digests map[string]string
+}
+
+func init() {
+	watchCommand.run = runWatch
+}
+
+func runWatch(args []string) (err error) {
+	flags := newFlagSet(watchCommand)
+
+	session := &watchSession{
+		report: addReportFlags(flags),
+	}
+
+	var outputPath string
+
+	flags.StringVar(&session.configPath, "config", epub.OptionsFileName, "`path` of the book's options file")
+	flags.StringVar(&outputPath, "output", "", "`path` of the generated book (default: the output_path option, or a name derived from the title next to the options file)")
+	flags.Var(&session.overrides, "set", "override an option, given as `key=value`; may be repeated")
+	interval := flags.Duration("interval", watchDefaultInterval, "how often to check the sources for changes")
+
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+
+	if err = session.report.validate(); err != nil {
+		return
+	}
+
+	if flags.NArg() > 0 {
+		return usageError{message: "unexpected argument: " + flags.Arg(0)}
+	}
+
+	if outputPath == outputStdout {
+		return usageError{message: "the book cannot be watched when written to standard output"}
+	}
+
+	if *interval <= 0 {
+		return usageError{message: "interval must be positive"}
+	}
+
+	session.write = func(ctx context.Context, book *epub.Book) (output string, digests map[string]string, err error) {
+		output = outputPath
+		if output == "" {
+			output = book.OutputPath()
+		}
+
+		if err = book.WriteFile(ctx, output, true); err != nil {
+			return
+		}
+
+		r, err := zip.OpenReader(output)
+		if err != nil {
+			return
+		}
+		defer r.Close()
+
+		digests, err = archiveDigests(&r.Reader)
+
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return session.watch(ctx, *interval)
+}
+
+// watch builds the book, then rebuilds it whenever its sources change,
+// until ctx is done.
+func (session *watchSession) watch(ctx context.Context, interval time.Duration) (err error) {
+	var changedSources []string
+
+	for {
+		// the sources are looked at before they are read by the build, so
+		// that changes made to them while the book is being built are seen
+		watcher := newSourceWatcher(session.sources)
+		start := time.Now()
+
+		if err = session.build(ctx, changedSources); err != nil {
+			break
+		}
+
+		// a source first read by the build can only be looked at afterwards
+		watcher.setPaths(session.sources, start)
+
+		if session.report.verbosity() >= verbosityVerbose && session.report.format == formatText {
+			fmt.Fprintf(os.Stderr, "watching %s\n", pluralize(len(session.sources), "source"))
+		}
+
+		if changedSources, err = watcher.wait(ctx, interval); err != nil {
+			break
+		}
+	}
+
+	// stopping the watch with an interrupt is how it is meant to end
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+
+	return
+}
+
+// build builds the book and reports how it differs from the previous build.
+// Problems with the book are reported rather than returned, so that the
+// watch can continue until they are fixed.
+func (session *watchSession) build(ctx context.Context, changedSources []string) (err error) {
+	start := time.Now()
+
+	result := watchReport{
+		ChangedSources: append([]string{}, changedSources...),
+		Diagnostics:    epub.Diagnostics{},
+		Added:          []string{},
+		Changed:        []string{},
+		Removed:        []string{},
+	}
+
+	initial := session.digests == nil
+
+	defer func() {
+		if err == nil {
+			result.Duration = time.Since(start).Milliseconds()
+			session.print(result, initial)
+		}
+	}()
+
+	options, optionsErr := loadOptions(session.configPath, session.overrides)
+	if optionsErr != nil {
+		result.Error = optionsErr.Error()
+		errors.As(optionsErr, &result.Diagnostics)
+
+		session.sources = appendUniqueStrings([]string{session.configPath}, session.sources...)
+
+		return
+	}
+
+	if session.book == nil {
+		session.book = epub.NewBook(options)
+	} else {
+		session.book.SetOptions(options)
+	}
+
+	book := session.book
+
+	output, digests, buildErr := session.write(ctx, book)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	result.Diagnostics = append(result.Diagnostics, book.Diagnostics()...)
+
+	sources := appendUniqueStrings([]string{session.configPath}, book.Sources()...)
+
+	if buildErr != nil {
+		var diagnostics epub.Diagnostics
+
+		if errors.As(buildErr, &diagnostics) {
+			result.Error = diagnosticsSummary(result.Diagnostics)
+		} else {
+			result.Error = buildErr.Error()
+		}
+
+		// a failed build may stop before reading every source
+		session.sources = appendUniqueStrings(sources, session.sources...)
+
+		return
+	}
+
+	session.sources = sources
+
+	result.Added, result.Changed, result.Removed = compareDigests(session.digests, digests)
+
+	session.digests = digests
+	result.Output = output
+
+	if session.report.verbosity() >= verbosityVerbose && session.report.format == formatText {
+		printBuildDetails(book)
+	}
+
+	return
+}
+
+func (session *watchSession) print(result watchReport, initial bool) {
+	if session.report.format == formatJSON {
+		printJSON(os.Stdout, result)
+		return
+	}
+
+	normal := session.report.verbosity() >= verbosityNormal
+
+	if normal && len(result.ChangedSources) > 0 {
+		fmt.Println("changed " + strings.Join(result.ChangedSources, ", "))
+	}
+
+	printDiagnostics(session.report, result.Diagnostics)
+
+	if result.Error != "" {
+		fmt.Fprintln(os.Stderr, "build failed: "+result.Error)
+		return
+	}
+
+	if !normal {
+		return
+	}
+
+	duration := (time.Duration(result.Duration) * time.Millisecond).String()
+
+	if initial {
+		if result.Output == "" {
+			fmt.Printf("built in %s\n", duration)
+		} else {
+			fmt.Printf("wrote %s in %s\n", result.Output, duration)
+		}
+
+		return
+	}
+
+	var parts []string
+
+	for _, group := range []struct {
+		verb  string
+		names []string
+	}{
+		{"changed", result.Changed},
+		{"added", result.Added},
+		{"removed", result.Removed},
+	} {
+		if len(group.names) > 0 {
+			parts = append(parts, group.verb+" "+strings.Join(group.names, ", "))
+		}
+	}
+
+	if len(parts) == 0 {
+		parts = append(parts, "no changes")
+	}
+
+	if result.Output == "" {
+		fmt.Printf("rebuilt in %s: %s\n", duration, strings.Join(parts, "; "))
+	} else {
+		fmt.Printf("rebuilt %s in %s: %s\n", result.Output, duration, strings.Join(parts, "; "))
+	}
+}
+
+// archiveDigests hashes every file in an EPUB archive.
+func archiveDigests(r *zip.Reader) (digests map[string]string, err error) {
+	digests = make(map[string]string, len(r.File))
+
+	for _, f := range r.File {
+		var rc io.ReadCloser
+
+		if rc, err = f.Open(); err != nil {
+			return
+		}
+
+		var b []byte
+
+		b, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return
+		}
+
+		b = watchModifiedRegexp.ReplaceAll(b, nil)
+
+		digests[f.Name] = hash.Uint256(b).Text(62)
+	}
+
+	return
+}
+
+// compareDigests lists the files that were added to, changed in or removed
+// from an archive between two builds, each sorted by name. Every file is
+// added when there is no previous build.
+func compareDigests(previous, current map[string]string) (added, changed, removed []string) {
+	added, changed, removed = []string{}, []string{}, []string{}
+
+	for name, digest := range current {
+		previousDigest, ok := previous[name]
+
+		switch {
+		case !ok:
+			added = append(added, name)
+		case previousDigest != digest:
+			changed = append(changed, name)
+		}
+	}
+
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+
+	return
+}
+
+func appendUniqueStrings(list []string, items ...string) []string {
+	for _, item := range items {
+		if !containsString(list, item) {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
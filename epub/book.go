@@ -0,0 +1,383 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+)
+
+// Book is an EPUB publication that can be built from its Options.
+// The results of the slower steps of a build are kept by the Book and
+// reused when it is built again from sources that have not changed.
+type Book struct {
+	options            Options
+	cache              *buildCache
+	diagnostics        []Diagnostic
+	imageOptimizations []ImageOptimization
+	fontSubsets        []FontSubset
+	sources            []string
+	headings           []Heading
+}
+
+// Heading is an entry in the table of contents of a book, as listed in its
+// navigation document.
+type Heading struct {
+	Text     string    `json:"text"`
+	Href     string    `json:"href"`
+	Level    int       `json:"level"`
+	Children []Heading `json:"children"`
+}
+
+// NewBook returns a Book that will be generated from the given options.
+func NewBook(options Options) *Book {
+	return &Book{
+		options: options,
+		cache:   newBuildCache(),
+	}
+}
+
+// LoadOptions reads and decodes the JSON options file found at path.
+func LoadOptions(path string) (options Options, err error) {
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(fileContent, &options); err != nil {
+		return options, optionsDecodeError(path, fileContent, err)
+	}
+
+	options.BaseDirectory = filepath.Dir(path)
+	options.OptionsPath = path
+
+	return
+}
+
+// optionsDecodeError adds the line on which decoding failed, where known,
+// to an error from decoding the options file at path.
+func optionsDecodeError(path string, content []byte, err error) error {
+	var offset int64
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+
+	return Diagnostics{{
+		Severity: SeverityError,
+		Path:     path,
+		Line:     bytes.Count(content[:offset], []byte("\n")) + 1,
+		Message:  err.Error(),
+		err:      err,
+	}}
+}
+
+// Set overrides the option found at key, which is a dot-separated path of
+// JSON names such as "title" or "paths.styles". Entries of map options are
+// named by their key, as in "text_languages.chapters/01.md". The value is
+// used as it is for string options and is otherwise decoded as JSON.
+func (options *Options) Set(key, value string) (err error) {
+	names, err := optionNames(key)
+	if err != nil {
+		return
+	}
+
+	b, err := json.Marshal(options)
+	if err != nil {
+		return
+	}
+
+	var root map[string]interface{}
+
+	if err = json.Unmarshal(b, &root); err != nil {
+		return
+	}
+
+	parent := root
+
+	for _, name := range names[:len(names)-1] {
+		child, ok := parent[name].(map[string]interface{})
+		if !ok {
+			// an empty map, such as text_languages, to which a key is being added
+			child = make(map[string]interface{})
+			parent[name] = child
+		}
+
+		parent = child
+	}
+
+	name := names[len(names)-1]
+
+	if _, isString := parent[name].(string); isString || !json.Valid([]byte(value)) {
+		parent[name] = value
+	} else {
+		parent[name] = json.RawMessage(value)
+	}
+
+	if b, err = json.Marshal(root); err != nil {
+		return
+	}
+
+	updated := Options{
+		BaseDirectory: options.BaseDirectory,
+		OptionsPath:   options.OptionsPath,
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+
+	if err = decoder.Decode(&updated); err != nil {
+		return errors.New("cannot set option " + key + ": " + err.Error())
+	}
+
+	*options = updated
+
+	return
+}
+
+// optionNames splits key into the JSON names of the fields it refers to,
+// keeping the key of a map entry whole even if it contains dots.
+func optionNames(key string) (names []string, err error) {
+	t := reflect.TypeOf(Options{})
+	rest := key
+
+	for rest != "" {
+		if t.Kind() == reflect.Map {
+			return append(names, rest), nil
+		}
+
+		if t.Kind() != reflect.Struct {
+			break
+		}
+
+		var name string
+
+		name, rest, _ = strings.Cut(rest, ".")
+
+		field, ok := optionField(t, name)
+		if !ok {
+			break
+		}
+
+		names = append(names, name)
+		t = field.Type
+
+		if rest == "" {
+			return
+		}
+	}
+
+	return nil, errors.New("unknown option: " + key)
+}
+
+func optionField(t reflect.Type, name string) (field reflect.StructField, ok bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field = t.Field(i)
+
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag == name && tag != "-" {
+			return field, true
+		}
+	}
+
+	return
+}
+
+// Options returns the options that the book was created with.
+func (b *Book) Options() Options {
+	return b.options
+}
+
+// SetOptions replaces the options that the book will next be built from,
+// such as when its options file has changed.
+func (b *Book) SetOptions(options Options) {
+	b.options = options
+}
+
+// FileName returns the conventional file name for the book,
+// derived from its title.
+func (b *Book) FileName() string {
+	return strcase.ToSnake(b.options.Title) + ".epub"
+}
+
+// OutputPath returns the path to which the book is written by default:
+// the output path given in its options, or else its file name, resolved
+// against the base directory.
+func (b *Book) OutputPath() string {
+	if b.options.OutputPath != "" {
+		return b.options.resolvePath(b.options.OutputPath)
+	}
+
+	return b.options.resolvePath(b.FileName())
+}
+
+// Warnings returns the problems that did not prevent the most recent
+// call to Build from succeeding.
+func (b *Book) Warnings() (warnings []string) {
+	for _, diagnostic := range Diagnostics(b.diagnostics).Warnings() {
+		warnings = append(warnings, diagnostic.String())
+	}
+
+	return
+}
+
+// Diagnostics returns every problem found by the most recent call to Build,
+// in the order in which they were found.
+func (b *Book) Diagnostics() Diagnostics {
+	return b.diagnostics
+}
+
+// ImageOptimizations reports the images optimised by the most recent
+// call to Build.
+func (b *Book) ImageOptimizations() []ImageOptimization {
+	return b.imageOptimizations
+}
+
+// FontSubsets reports the fonts subsetted by the most recent call to Build.
+func (b *Book) FontSubsets() []FontSubset {
+	return b.fontSubsets
+}
+
+// Sources returns the paths of the files and directories read by the most
+// recent call to Build, other than the options file, including those that
+// could not be read. A change to any of them may change the book.
+func (b *Book) Sources() []string {
+	return b.sources
+}
+
+// Headings returns the table of contents of the book built by the most
+// recent call to Build, whose links are relative to the root of its archive.
+func (b *Book) Headings() []Heading {
+	return b.headings
+}
+
+// Build reads every source file referenced by the book's options
+// and writes the resulting EPUB archive to w, which may be a file,
+// standard output or an HTTP response. Problems found in the sources are
+// collected rather than stopping the build at the first, and the returned
+// error is of type Diagnostics if any of them prevent the book being built.
+func (b *Book) Build(ctx context.Context, w io.Writer) (err error) {
+	ei := &epubInfo{
+		Options: b.options,
+		cache:   b.cache,
+	}
+
+	defer func() {
+		b.cache.finish(err == nil)
+	}()
+
+	err = epubInfoOutputInit(ctx, ei)
+
+	b.diagnostics = ei.output.diagnostics
+	b.imageOptimizations = ei.output.imageOptimizations
+	b.fontSubsets = ei.output.fontSubsets
+	b.sources = ei.output.sources
+	b.headings = newHeadings(ei.output.textHeadingsTree)
+
+	if err != nil {
+		return
+	}
+
+	if err = generateZip(ctx, ei, w); err != nil {
+		return
+	}
+
+	return
+}
+
+// WriteFile builds the book and writes it to the file at path. The archive
+// is written to a temporary file in the same directory, which is renamed
+// to path once complete, so an existing file is never left partly written
+// and nothing is left behind if the build fails. Unless overwrite is true,
+// an error wrapping fs.ErrExist is returned if a file already exists at path
+// once the book has been built, so that the diagnostics of the build are
+// still reported.
+func (b *Book) WriteFile(ctx context.Context, path string, overwrite bool) (err error) {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		// report the path of the book rather than that of the temporary file
+		if pathErr, ok := err.(*fs.PathError); ok {
+			pathErr.Op = "write"
+			pathErr.Path = path
+		}
+
+		return
+	}
+
+	tempPath := tempFile.Name()
+
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	if err = b.Build(ctx, tempFile); err != nil {
+		return
+	}
+
+	if err = tempFile.Chmod(0644); err != nil {
+		return
+	}
+
+	if err = tempFile.Sync(); err != nil {
+		return
+	}
+
+	if err = tempFile.Close(); err != nil {
+		return
+	}
+
+	if overwrite {
+		return os.Rename(tempPath, path)
+	}
+
+	// linking, unlike renaming, fails rather than replace a file created
+	// at path while the book was being built
+	if err = os.Link(tempPath, path); err != nil {
+		// the file system may not support hard links, so the file is looked
+		// for whatever the error
+		if _, statErr := os.Lstat(path); errors.Is(err, fs.ErrExist) || statErr == nil {
+			return &fs.PathError{Op: "write", Path: path, Err: fs.ErrExist}
+		}
+
+		return os.Rename(tempPath, path)
+	}
+
+	os.Remove(tempPath)
+
+	return
+}
+
+func newHeadings(textHeadings []*epubInfoOutputTextHeading) (headings []Heading) {
+	for _, heading := range textHeadings {
+		headings = append(headings, Heading{
+			Text:     heading.text,
+			Href:     heading.href(),
+			Level:    heading.level,
+			Children: newHeadings(heading.children),
+		})
+	}
+
+	return
+}
@@ -0,0 +1,389 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildEscapesMetadata(t *testing.T) {
+	const hostile = "Fish & <Chips> \"quoted\" 'single' ]]> \x01\x0b\x1f end"
+
+	// Markdown treats <Chips> as an HTML tag and typesets the quotes
+	const markdownHeading = "Fish & “quoted” ‘single’ ]]> \x01\x0b\x1f end"
+
+	tests := []struct {
+		name    string
+		version int
+		text    string
+		ext     string
+		heading string
+	}{
+		{"epub2 markdown", 2, "# " + hostile + "\n\nA paragraph & more.\n\n## Sub " + hostile + "\n\nText.\n", ".md", markdownHeading},
+		{"epub3 markdown", 3, "# " + hostile + "\n\nA paragraph & more.\n\n## Sub " + hostile + "\n\nText.\n", ".md", markdownHeading},
+		{"epub3 html", 3, "<html><body><h1>Fish &amp; &lt;Chips&gt; &quot;quoted&quot; \x01</h1><p>Text.</p></body></html>", ".html", "Fish & <Chips> \"quoted\" \x01"},
+	}
+
+	// characters that may not appear in XML are replaced, but nothing else
+	// about the metadata should change on its way into the book
+	sanitize := func(s string) string {
+		return string(xmlSanitize([]byte(s)))
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			if err := os.WriteFile(filepath.Join(dir, "text"+test.ext), []byte(test.text), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			options := Options{
+				EPUBVersion:          test.version,
+				Title:                hostile,
+				Author:               hostile,
+				Publisher:            hostile,
+				Description:          hostile,
+				Rights:               hostile,
+				Subjects:             []string{hostile},
+				Series:               Series{Name: hostile, Index: 1},
+				Contributors:         []Contributor{{Name: hostile, Role: ContributorRoleEditor, FileAs: hostile}},
+				MaxHeadingDepth:      2,
+				IncludeContentsPage:  true,
+				IncludeCopyrightPage: true,
+				ShouldGenerateCover:  true,
+				BaseDirectory:        dir,
+			}
+
+			options.Paths.Text = "text" + test.ext
+
+			var buffer bytes.Buffer
+
+			if err := NewBook(options).Build(context.Background(), &buffer); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var checked []string
+
+			for _, f := range r.File {
+				switch filepath.Ext(f.Name) {
+				case ".opf", ".ncx", ".xhtml", ".xml":
+				default:
+					continue
+				}
+
+				checked = append(checked, f.Name)
+
+				if err := parseXML(f); err != nil {
+					t.Errorf("%s is not well-formed: %v", f.Name, err)
+				}
+			}
+
+			for _, name := range []string{"content.opf", "toc.ncx", "title.xhtml", "copyright.xhtml", "contents.xhtml", "cover.xhtml"} {
+				if !containsName(checked, name) {
+					t.Errorf("%s is missing from the archive", name)
+				}
+			}
+
+			if test.version == 3 && !containsName(checked, "nav.xhtml") {
+				t.Error("nav.xhtml is missing from the archive")
+			}
+
+			problems, err := ValidateReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, problem := range problems {
+				t.Error(problem)
+			}
+
+			want := sanitize(hostile)
+
+			opf := xmlElementTexts(t, r, "content.opf")
+
+			for _, name := range []string{"title", "creator", "contributor", "publisher", "description", "rights", "subject"} {
+				if !containsName(opf[name], want) {
+					t.Errorf("content.opf: got %s %q, want %q", name, opf[name], want)
+				}
+			}
+
+			if ncx := xmlElementTexts(t, r, "toc.ncx"); !containsName(ncx["text"], want) || !containsName(ncx["text"], sanitize(test.heading)) {
+				t.Errorf("toc.ncx: got %q, want %q and %q", ncx["text"], want, sanitize(test.heading))
+			}
+
+			if title := xmlElementTexts(t, r, "title.xhtml"); !containsName(title["h1"], want) {
+				t.Errorf("title.xhtml: got %q, want %q", title["h1"], want)
+			}
+
+			if test.version == 3 {
+				if nav := xmlElementTexts(t, r, "nav.xhtml"); !containsName(nav["a"], sanitize(test.heading)) {
+					t.Errorf("nav.xhtml: got %q, want %q", nav["a"], sanitize(test.heading))
+				}
+			}
+		})
+	}
+}
+
+func TestOptionsSet(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		get   func(options Options) interface{}
+		want  interface{}
+		error bool
+	}{
+		{key: "title", value: "New & Improved", get: func(o Options) interface{} { return o.Title }, want: "New & Improved"},
+		{key: "title", value: "123", get: func(o Options) interface{} { return o.Title }, want: "123"},
+		{key: "title", value: `"quoted"`, get: func(o Options) interface{} { return o.Title }, want: `"quoted"`},
+		{key: "epub_version", value: "3", get: func(o Options) interface{} { return o.EPUBVersion }, want: 3},
+		{key: "should_split_text_files", value: "true", get: func(o Options) interface{} { return o.ShouldSplitTextFiles }, want: true},
+		{key: "paths.styles", value: "print.css", get: func(o Options) interface{} { return o.Paths.Styles }, want: "print.css"},
+		{key: "cover.max_width", value: "1600", get: func(o Options) interface{} { return o.Cover.MaxWidth }, want: 1600},
+		{key: "subjects", value: `["a","b"]`, get: func(o Options) interface{} { return o.Subjects }, want: []string{"a", "b"}},
+		{key: "text_languages.chapters/01.v2.md", value: "fr", get: func(o Options) interface{} { return o.TextLanguages }, want: map[string]string{"a.md": "de", "chapters/01.v2.md": "fr"}},
+		{key: "text_languages.a.md", value: "es", get: func(o Options) interface{} { return o.TextLanguages }, want: map[string]string{"a.md": "es"}},
+		{key: "base_directory", value: "/", error: true},
+		{key: "unknown", value: "x", error: true},
+		{key: "paths.unknown", value: "x", error: true},
+		{key: "epub_version", value: "three", error: true},
+		{key: "title.nested", value: "x", error: true},
+		{key: "", value: "x", error: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key+"="+test.value, func(t *testing.T) {
+			options := Options{
+				Title:         "Original",
+				TextLanguages: map[string]string{"a.md": "de"},
+				BaseDirectory: "books",
+				OptionsPath:   "books/epub_info.json",
+			}
+
+			err := options.Set(test.key, test.value)
+
+			switch {
+			case test.error && err == nil:
+				t.Fatal("want an error, got none")
+			case !test.error && err != nil:
+				t.Fatal(err)
+			case test.error:
+				return
+			}
+
+			if got := test.get(options); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+
+			if options.Title != "Original" && test.key != "title" {
+				t.Errorf("title changed to %q", options.Title)
+			}
+
+			if options.BaseDirectory != "books" || options.OptionsPath != "books/epub_info.json" {
+				t.Errorf("paths changed to %q and %q", options.BaseDirectory, options.OptionsPath)
+			}
+		})
+	}
+}
+
+func TestBookWriteFile(t *testing.T) {
+	sourceDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(sourceDir, "text.md"), []byte("# One\n\nText.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newTestBook := func(title, text string) *Book {
+		options := Options{Title: title, BaseDirectory: sourceDir}
+
+		options.Paths.Text = text
+
+		return NewBook(options)
+	}
+
+	outputDir := t.TempDir()
+	path := filepath.Join(outputDir, "book.epub")
+
+	// checkOutput fails unless the book is the only file in the output
+	// directory, so that no temporary file has been left behind
+	checkOutput := func(want string) {
+		t.Helper()
+
+		entries, err := os.ReadDir(outputDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+
+		if want == "" {
+			if len(names) != 0 {
+				t.Fatalf("want no files, got %q", names)
+			}
+
+			return
+		}
+
+		if len(names) != 1 || names[0] != "book.epub" {
+			t.Fatalf("want only book.epub, got %q", names)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != 0644 {
+			t.Errorf("book has mode %v, want 0644", info.Mode().Perm())
+		}
+
+		problems, err := Validate(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, problem := range problems {
+			t.Error(problem)
+		}
+
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		if opf := readTestArchiveFile(t, &r.Reader, "content.opf"); !strings.Contains(opf, "<dc:title>"+want+"</dc:title>") {
+			t.Errorf("book is not titled %q", want)
+		}
+	}
+
+	if err := newTestBook("First", "missing.md").WriteFile(context.Background(), path, false); err == nil {
+		t.Fatal("want an error from a book that cannot be built, got none")
+	}
+
+	checkOutput("")
+
+	if err := newTestBook("First", "text.md").WriteFile(context.Background(), path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	checkOutput("First")
+
+	err := newTestBook("Second", "text.md").WriteFile(context.Background(), path, false)
+
+	var pathErr *fs.PathError
+
+	if !errors.Is(err, fs.ErrExist) || !errors.As(err, &pathErr) || pathErr.Path != path {
+		t.Fatalf("want an error saying that %s exists, got %v", path, err)
+	}
+
+	checkOutput("First")
+
+	if err := newTestBook("Second", "text.md").WriteFile(context.Background(), path, true); err != nil {
+		t.Fatal(err)
+	}
+
+	checkOutput("Second")
+
+	if err := newTestBook("Third", "missing.md").WriteFile(context.Background(), path, true); err == nil {
+		t.Fatal("want an error from a book that cannot be built, got none")
+	}
+
+	checkOutput("Second")
+
+	if err := newTestBook("Fourth", "text.md").WriteFile(context.Background(), filepath.Join(outputDir, "missing", "book.epub"), true); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want an error saying that the directory does not exist, got %v", err)
+	}
+
+	checkOutput("Second")
+}
+
+func parseXML(f *zip.File) (err error) {
+	rc, err := f.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(rc)
+	decoder.Strict = true
+
+	for {
+		if _, err = decoder.Token(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+
+			return
+		}
+	}
+}
+
+// xmlElementTexts returns the text directly inside each element of the
+// named file in an archive, by the element's local name.
+func xmlElementTexts(t *testing.T, r *zip.Reader, name string) map[string][]string {
+	t.Helper()
+
+	decoder := xml.NewDecoder(strings.NewReader(readTestArchiveFile(t, r, name)))
+	decoder.Strict = true
+
+	texts := make(map[string][]string)
+
+	var names []string
+	var builders []*strings.Builder
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			names = append(names, token.Name.Local)
+			builders = append(builders, &strings.Builder{})
+		case xml.CharData:
+			if len(builders) > 0 {
+				builders[len(builders)-1].Write(token)
+			}
+		case xml.EndElement:
+			last := len(names) - 1
+
+			texts[names[last]] = append(texts[names[last]], builders[last].String())
+			names, builders = names[:last], builders[:last]
+		}
+	}
+
+	return texts
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,45 @@
+package epub
+
+// buildCache holds the results of the slower steps of building a book,
+// such as optimising its images, keyed by everything that they depend on,
+// so that rebuilding the book only repeats the steps whose inputs have
+// changed. Entries not used by a successful build are evicted at its end,
+// so the cache never holds more than a single build needs.
+type buildCache struct {
+	entries map[string][]byte
+	used    map[string]struct{}
+}
+
+func newBuildCache() *buildCache {
+	return &buildCache{
+		entries: make(map[string][]byte),
+		used:    make(map[string]struct{}),
+	}
+}
+
+func (cache *buildCache) load(key string) (b []byte, ok bool) {
+	if b, ok = cache.entries[key]; ok {
+		cache.used[key] = struct{}{}
+	}
+
+	return
+}
+
+func (cache *buildCache) store(key string, b []byte) {
+	cache.entries[key] = b
+	cache.used[key] = struct{}{}
+}
+
+// finish ends a build. The entries left unused are only evicted when the
+// build succeeded, as a failed build may have stopped before using them.
+func (cache *buildCache) finish(succeeded bool) {
+	if succeeded {
+		for key := range cache.entries {
+			if _, ok := cache.used[key]; !ok {
+				delete(cache.entries, key)
+			}
+		}
+	}
+
+	cache.used = make(map[string]struct{})
+}
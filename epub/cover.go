@@ -0,0 +1,549 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// CoverOptions controls how a raster cover image is resized before it is
+// embedded, or how one is drawn when the book has no cover image of its own.
+// A preset, such as "kindle", "apple" or "kobo", supplies any dimensions
+// and aspect ratio that are not set explicitly.
+type CoverOptions struct {
+	Preset      string `json:"preset"`
+	MaxWidth    int    `json:"max_width"`
+	MaxHeight   int    `json:"max_height"`
+	AspectRatio string `json:"aspect_ratio"`
+	Fit         string `json:"fit"`
+	Background  string `json:"background"`
+	GradientTo  string `json:"gradient_to"`
+	TextColor   string `json:"text_color"`
+	JPEGQuality int    `json:"jpeg_quality"`
+}
+
+const (
+	CoverFitLetterbox = "letterbox"
+	CoverFitCrop      = "crop"
+)
+
+var (
+	coverPresets = map[string]CoverOptions{
+		"kindle": {MaxWidth: 1600, MaxHeight: 2560, AspectRatio: "1:1.6"},
+		"apple":  {MaxWidth: 1600, MaxHeight: 2400, AspectRatio: "2:3"},
+		"kobo":   {MaxWidth: 1072, MaxHeight: 1448, AspectRatio: "1072:1448"},
+	}
+)
+
+func (options *CoverOptions) applyPreset() (err error) {
+	if options.Preset == "" {
+		return
+	}
+
+	preset, ok := coverPresets[strings.ToLower(options.Preset)]
+	if !ok {
+		return errors.New("unknown cover preset: " + options.Preset)
+	}
+
+	if options.MaxWidth == 0 && options.MaxHeight == 0 {
+		options.MaxWidth = preset.MaxWidth
+		options.MaxHeight = preset.MaxHeight
+	}
+
+	if options.AspectRatio == "" {
+		options.AspectRatio = preset.AspectRatio
+	}
+
+	return
+}
+
+// validateCoverOptions applies the cover preset and records a diagnostic for
+// each of the cover options that cannot be used, reporting whether all can.
+func (ei *epubInfo) validateCoverOptions() (valid bool) {
+	valid = true
+
+	invalid := func(value, message string) {
+		ei.failOption(`"`+value+`"`, message)
+		valid = false
+	}
+
+	if err := ei.Cover.applyPreset(); err != nil {
+		invalid(ei.Cover.Preset, err.Error())
+	}
+
+	if ei.Cover.AspectRatio != "" {
+		if _, err := parseAspectRatio(ei.Cover.AspectRatio); err != nil {
+			invalid(ei.Cover.AspectRatio, err.Error())
+		}
+	}
+
+	switch ei.Cover.Fit {
+	case "", CoverFitLetterbox, CoverFitCrop:
+	default:
+		invalid(ei.Cover.Fit, `cover fit must be "letterbox" or "crop": `+ei.Cover.Fit)
+	}
+
+	if ei.Cover.MaxWidth < 0 || ei.Cover.MaxHeight < 0 {
+		ei.failOption(`"cover"`, "cover dimensions cannot be negative: "+strconv.Itoa(ei.Cover.MaxWidth)+"x"+strconv.Itoa(ei.Cover.MaxHeight))
+		valid = false
+	}
+
+	if !validJPEGQuality(ei.Cover.JPEGQuality) {
+		ei.failOption(`"jpeg_quality"`, "cover JPEG quality must be between 1 and 100: "+strconv.Itoa(ei.Cover.JPEGQuality))
+		valid = false
+	}
+
+	for _, colour := range []string{ei.Cover.Background, ei.Cover.GradientTo, ei.Cover.TextColor} {
+		if _, err := parseHexColor(colour, nil); err != nil {
+			invalid(colour, err.Error())
+		}
+	}
+
+	return
+}
+
+func (options *CoverOptions) isProcessed() bool {
+	return options.MaxWidth > 0 || options.MaxHeight > 0 || options.AspectRatio != "" || options.JPEGQuality > 0
+}
+
+type epubInfoOutputCoverImage struct {
+	format  string
+	content []byte
+	width   int
+	height  int
+}
+
+var (
+	coverImageMediaTypes = map[string]string{
+		"gif":  "image/gif",
+		"jpeg": "image/jpeg",
+		"png":  "image/png",
+		"svg":  "image/svg+xml",
+		"webp": "image/webp",
+	}
+
+	coverImageEncodableFormats = map[string]struct{}{
+		"gif":  {},
+		"jpeg": {},
+		"png":  {},
+	}
+)
+
+func (coverImage *epubInfoOutputCoverImage) mediaType() string {
+	return coverImageMediaTypes[coverImage.format]
+}
+
+func (coverImage *epubInfoOutputCoverImage) path() string {
+	if coverImage.format == "jpeg" {
+		return "cover.jpg"
+	}
+
+	return "cover." + coverImage.format
+}
+
+func epubInfoOutputInitCoverImage(ei *epubInfo) (err error) {
+	if ei.Paths.CoverImage == "" && !ei.ShouldGenerateCover {
+		return
+	}
+
+	targetFormat := strings.ToLower(ei.CoverImageFormat)
+
+	if targetFormat == "jpg" {
+		targetFormat = "jpeg"
+	}
+
+	// the book is checked for other problems without a cover
+	if !ei.validateCoverOptions() {
+		return
+	}
+
+	if ei.Paths.CoverImage == "" {
+		return ei.initGeneratedCoverImage(targetFormat)
+	}
+
+	coverPath := ei.resolvePath(ei.Paths.CoverImage)
+
+	ei.addSource(coverPath)
+
+	b, err := os.ReadFile(coverPath)
+	if err != nil {
+		return
+	}
+
+	if strings.EqualFold(filepath.Ext(ei.Paths.CoverImage), ".svg") {
+		if targetFormat != "" && targetFormat != "svg" {
+			return errors.New("cannot convert SVG cover image to " + targetFormat)
+		}
+
+		if ei.Cover.isProcessed() {
+			ei.warn(coverPath, 0, "cover image processing options are ignored for SVG cover images")
+		}
+
+		ei.output.coverImage, err = newSVGCoverImage(b)
+
+		return
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+
+	coverImage := &epubInfoOutputCoverImage{
+		format:  format,
+		content: b,
+		width:   config.Width,
+		height:  config.Height,
+	}
+
+	if ei.Cover.isProcessed() || targetFormat != "" && targetFormat != format {
+		if message := coverSizeWarning(config.Width, config.Height, ei.Cover); message != "" {
+			ei.warn(coverPath, 0, message)
+		}
+
+		if targetFormat == "" {
+			targetFormat = format
+		}
+
+		if _, ok := coverImageEncodableFormats[targetFormat]; !ok {
+			targetFormat = "jpeg"
+		}
+
+		// the processed cover is reused while its source and options are
+		// unchanged
+		var optionsJSON []byte
+
+		if optionsJSON, err = json.Marshal(ei.Cover); err != nil {
+			return
+		}
+
+		key := "cover_" + ei.sourceDigest(coverPath, b) + "_" + targetFormat + "_" + string(optionsJSON)
+
+		content, ok := ei.cache.load(key)
+		if !ok {
+			var img image.Image
+
+			if img, _, err = image.Decode(bytes.NewReader(b)); err != nil {
+				return
+			}
+
+			if img, err = ei.processCoverImage(img); err != nil {
+				return
+			}
+
+			if content, err = encodeCoverImage(img, targetFormat, ei.Cover.JPEGQuality); err != nil {
+				return
+			}
+
+			ei.cache.store(key, content)
+		}
+
+		if config, _, err = image.DecodeConfig(bytes.NewReader(content)); err != nil {
+			return
+		}
+
+		coverImage.content = content
+		coverImage.format = targetFormat
+		coverImage.width = config.Width
+		coverImage.height = config.Height
+	}
+
+	if _, ok := coverImageMediaTypes[coverImage.format]; !ok {
+		return errors.New("unsupported cover image format: " + coverImage.format)
+	}
+
+	ei.output.coverImage = coverImage
+
+	return
+}
+
+// coverSizeWarning describes how a cover image of the given size falls short
+// of the recommended maximum dimensions, each of which is checked on its own,
+// or returns an empty string if it does not.
+func coverSizeWarning(width, height int, options CoverOptions) string {
+	var shortfalls []string
+
+	if options.MaxWidth > 0 && width < options.MaxWidth {
+		shortfalls = append(shortfalls, "narrower than the recommended "+strconv.Itoa(options.MaxWidth)+" pixels")
+	}
+
+	if options.MaxHeight > 0 && height < options.MaxHeight {
+		shortfalls = append(shortfalls, "shorter than the recommended "+strconv.Itoa(options.MaxHeight)+" pixels")
+	}
+
+	if len(shortfalls) == 0 {
+		return ""
+	}
+
+	return "cover image is " + strconv.Itoa(width) + "x" + strconv.Itoa(height) + ", " + strings.Join(shortfalls, " and ")
+}
+
+func (ei *epubInfo) initGeneratedCoverImage(targetFormat string) (err error) {
+	img, err := ei.generateCoverImage()
+	if err != nil {
+		return
+	}
+
+	if targetFormat == "" {
+		targetFormat = "png"
+	}
+
+	content, err := encodeCoverImage(img, targetFormat, ei.Cover.JPEGQuality)
+	if err != nil {
+		return
+	}
+
+	ei.output.coverImage = &epubInfoOutputCoverImage{
+		format:  targetFormat,
+		content: content,
+		width:   img.Bounds().Dx(),
+		height:  img.Bounds().Dy(),
+	}
+
+	return
+}
+
+func (ei *epubInfo) processCoverImage(img image.Image) (processed image.Image, err error) {
+	options := ei.Cover
+
+	processed = img
+
+	if options.AspectRatio != "" {
+		var ratio float64
+
+		if ratio, err = parseAspectRatio(options.AspectRatio); err != nil {
+			return
+		}
+
+		switch options.Fit {
+		case "", CoverFitLetterbox:
+			var background color.Color
+
+			if background, err = parseHexColor(options.Background, color.White); err != nil {
+				return
+			}
+
+			processed = letterboxImage(processed, ratio, background)
+		case CoverFitCrop:
+			processed = cropImage(processed, ratio)
+		default:
+			err = errors.New(`cover fit must be "letterbox" or "crop": ` + options.Fit)
+			return
+		}
+	}
+
+	processed = shrinkImage(processed, options.MaxWidth, options.MaxHeight)
+
+	return
+}
+
+func letterboxImage(img image.Image, ratio float64, background color.Color) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	newWidth, newHeight := width, height
+
+	if float64(width)/float64(height) > ratio {
+		newHeight = int(math.Round(float64(width) / ratio))
+	} else {
+		newWidth = int(math.Round(float64(height) * ratio))
+	}
+
+	if newWidth == width && newHeight == height {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	offset := image.Pt((newWidth-width)/2, (newHeight-height)/2)
+
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, bounds.Sub(bounds.Min).Add(offset), img, bounds.Min, draw.Over)
+
+	return dst
+}
+
+func cropImage(img image.Image, ratio float64) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	newWidth, newHeight := width, height
+
+	if float64(width)/float64(height) > ratio {
+		newWidth = int(math.Round(float64(height) * ratio))
+	} else {
+		newHeight = int(math.Round(float64(width) / ratio))
+	}
+
+	if newWidth == width && newHeight == height {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	offset := image.Pt(bounds.Min.X+(width-newWidth)/2, bounds.Min.Y+(height-newHeight)/2)
+
+	draw.Draw(dst, dst.Bounds(), img, offset, draw.Src)
+
+	return dst
+}
+
+// shrinkImage scales an image down, preserving its aspect ratio,
+// until it fits within the given dimensions; zero means no limit.
+func shrinkImage(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	scale := 1.0
+
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+
+	if maxHeight > 0 && height > maxHeight {
+		if heightScale := float64(maxHeight) / float64(height); heightScale < scale {
+			scale = heightScale
+		}
+	}
+
+	if scale == 1 {
+		return img
+	}
+
+	newWidth := int(math.Max(1, math.Round(float64(width)*scale)))
+	newHeight := int(math.Max(1, math.Round(float64(height)*scale)))
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+	return dst
+}
+
+func parseAspectRatio(s string) (ratio float64, err error) {
+	if width, height, found := strings.Cut(s, ":"); found {
+		var w, h float64
+
+		w, err = strconv.ParseFloat(strings.TrimSpace(width), 64)
+		if err == nil {
+			h, err = strconv.ParseFloat(strings.TrimSpace(height), 64)
+		}
+		if err == nil && h > 0 {
+			ratio = w / h
+		}
+	} else {
+		ratio, err = strconv.ParseFloat(s, 64)
+	}
+
+	if err != nil || ratio <= 0 {
+		return 0, errors.New("invalid aspect ratio: " + s)
+	}
+
+	return
+}
+
+func parseHexColor(s string, defaultColor color.Color) (c color.Color, err error) {
+	if s == "" {
+		return defaultColor, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	value, parseErr := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || parseErr != nil {
+		return nil, errors.New("invalid colour: " + s)
+	}
+
+	c = color.RGBA{
+		R: uint8(value >> 16),
+		G: uint8(value >> 8),
+		B: uint8(value),
+		A: 0xff,
+	}
+
+	return
+}
+
+func encodeCoverImage(img image.Image, format string, jpegQuality int) (b []byte, err error) {
+	var buffer bytes.Buffer
+
+	switch format {
+	case "png":
+		err = png.Encode(&buffer, img)
+	case "jpeg":
+		var options *jpeg.Options
+
+		if jpegQuality > 0 {
+			options = &jpeg.Options{Quality: jpegQuality}
+		}
+
+		err = jpeg.Encode(&buffer, img, options)
+	case "gif":
+		err = gif.Encode(&buffer, img, nil)
+	default:
+		err = errors.New("cannot encode cover image as " + format)
+	}
+	if err != nil {
+		return
+	}
+
+	b = buffer.Bytes()
+
+	return
+}
+
+func newSVGCoverImage(b []byte) (coverImage *epubInfoOutputCoverImage, err error) {
+	var root struct {
+		XMLName xml.Name
+		Width   string `xml:"width,attr"`
+		Height  string `xml:"height,attr"`
+		ViewBox string `xml:"viewBox,attr"`
+	}
+
+	if err = xml.Unmarshal(b, &root); err != nil {
+		return
+	}
+
+	if root.XMLName.Local != "svg" {
+		err = errors.New("cover image is not an SVG document")
+		return
+	}
+
+	var width, height float64
+
+	if viewBox := strings.Fields(strings.ReplaceAll(root.ViewBox, ",", " ")); len(viewBox) == 4 {
+		width, _ = strconv.ParseFloat(viewBox[2], 64)
+		height, _ = strconv.ParseFloat(viewBox[3], 64)
+	}
+
+	if width <= 0 || height <= 0 {
+		width, _ = strconv.ParseFloat(strings.TrimSuffix(root.Width, "px"), 64)
+		height, _ = strconv.ParseFloat(strings.TrimSuffix(root.Height, "px"), 64)
+	}
+
+	if width <= 0 || height <= 0 {
+		err = errors.New("cannot determine dimensions of SVG cover image")
+		return
+	}
+
+	coverImage = &epubInfoOutputCoverImage{
+		format:  "svg",
+		content: b,
+		width:   int(math.Round(width)),
+		height:  int(math.Round(height)),
+	}
+
+	return
+}
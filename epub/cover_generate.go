@@ -0,0 +1,243 @@
+package epub
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	coverGenerateDefaultWidth  = 1600
+	coverGenerateDefaultHeight = 2560
+	// a generated cover is held in memory uncompressed, so its size is
+	// limited to well beyond what any reading system recommends
+	coverGenerateMaxDimension = 4096
+)
+
+var (
+	coverGenerateDefaultBackground = color.RGBA{R: 0x1f, G: 0x2a, B: 0x44, A: 0xff}
+	coverGenerateDefaultTextColor  = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+// generateCoverImage renders the title, edition and author of the book
+// onto a solid or gradient background, for books without a cover image.
+func (ei *epubInfo) generateCoverImage() (img image.Image, err error) {
+	width, height, err := ei.generatedCoverImageSize()
+	if err != nil {
+		return
+	}
+
+	top, err := parseHexColor(ei.Cover.Background, coverGenerateDefaultBackground)
+	if err != nil {
+		return
+	}
+
+	bottom, err := parseHexColor(ei.Cover.GradientTo, top)
+	if err != nil {
+		return
+	}
+
+	textColor, err := parseHexColor(ei.Cover.TextColor, coverGenerateDefaultTextColor)
+	if err != nil {
+		return
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	fillGradient(dst, top, bottom)
+
+	maxLineWidth := width * 4 / 5
+
+	var edition string
+
+	if ei.EditionNumber > 0 {
+		edition = humanize.Ordinal(ei.EditionNumber) + " Edition"
+	}
+
+	titleFace, err := newFittedCoverFontFace(gobold.TTF, float64(width)/10, ei.Title, maxLineWidth)
+	if err != nil {
+		return
+	}
+	defer titleFace.Close()
+
+	detailFace, err := newFittedCoverFontFace(goregular.TTF, float64(width)/20, edition+" "+ei.Author, maxLineWidth)
+	if err != nil {
+		return
+	}
+	defer detailFace.Close()
+
+	y := height * 3 / 10
+
+	y = drawCenteredText(dst, titleFace, textColor, ei.Title, maxLineWidth, y)
+
+	if edition != "" {
+		y += height / 40
+		drawCenteredText(dst, detailFace, textColor, edition, maxLineWidth, y)
+	}
+
+	if ei.Author != "" {
+		drawCenteredText(dst, detailFace, textColor, ei.Author, maxLineWidth, height*4/5)
+	}
+
+	img = dst
+
+	return
+}
+
+func (ei *epubInfo) generatedCoverImageSize() (width, height int, err error) {
+	width, height = ei.Cover.MaxWidth, ei.Cover.MaxHeight
+
+	if width == 0 {
+		width = coverGenerateDefaultWidth
+	}
+
+	if height == 0 {
+		height = coverGenerateDefaultHeight
+
+		if ei.Cover.AspectRatio != "" {
+			var ratio float64
+
+			if ratio, err = parseAspectRatio(ei.Cover.AspectRatio); err != nil {
+				return
+			}
+
+			height = int(math.Max(1, float64(width)/ratio))
+		}
+	}
+
+	// the cover is scaled down to the largest size allowed, keeping its shape
+	if width > coverGenerateMaxDimension || height > coverGenerateMaxDimension {
+		scale := math.Min(float64(coverGenerateMaxDimension)/float64(width), float64(coverGenerateMaxDimension)/float64(height))
+		clampedWidth := int(math.Max(1, math.Round(float64(width)*scale)))
+		clampedHeight := int(math.Max(1, math.Round(float64(height)*scale)))
+
+		ei.warn(ei.OptionsPath, fileLine(ei.OptionsPath, `"cover"`), "generated cover image would be "+strconv.Itoa(width)+"x"+strconv.Itoa(height)+
+			", larger than the maximum of "+strconv.Itoa(coverGenerateMaxDimension)+" pixels in either direction, so is "+strconv.Itoa(clampedWidth)+"x"+strconv.Itoa(clampedHeight))
+
+		width, height = clampedWidth, clampedHeight
+	}
+
+	return
+}
+
+func fillGradient(dst *image.RGBA, top, bottom color.Color) {
+	bounds := dst.Bounds()
+	tr, tg, tb, _ := top.RGBA()
+	br, bg, bb, _ := bottom.RGBA()
+	lerp := func(from, to uint32, t float64) uint8 {
+		return uint8((float64(from)*(1-t) + float64(to)*t) / 0x101)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		var t float64
+
+		if bounds.Dy() > 1 {
+			t = float64(y-bounds.Min.Y) / float64(bounds.Dy()-1)
+		}
+
+		c := color.RGBA{R: lerp(tr, br, t), G: lerp(tg, bg, t), B: lerp(tb, bb, t), A: 0xff}
+
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dst.SetRGBA(x, y, c)
+		}
+	}
+}
+
+func newCoverFontFace(ttf []byte, size float64) (face font.Face, err error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return
+	}
+
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// newFittedCoverFontFace returns a face of the given size, unless the
+// widest word of text, which cannot be wrapped, would not fit within
+// maxLineWidth, in which case the face is made small enough for it to fit.
+func newFittedCoverFontFace(ttf []byte, size float64, text string, maxLineWidth int) (face font.Face, err error) {
+	for {
+		if face, err = newCoverFontFace(ttf, size); err != nil {
+			return
+		}
+
+		var widest int
+
+		for _, word := range strings.Fields(text) {
+			if wordWidth := font.MeasureString(face, word).Ceil(); wordWidth > widest {
+				widest = wordWidth
+			}
+		}
+
+		if widest <= maxLineWidth || size <= 1 {
+			return
+		}
+
+		face.Close()
+
+		// hinting stops widths scaling exactly with size, so the size is
+		// reduced to a whole number, and again if the word still does not fit
+		size = math.Max(1, math.Floor(size*float64(maxLineWidth)/float64(widest)))
+	}
+}
+
+// drawCenteredText word-wraps text to maxLineWidth, draws each line centred
+// horizontally with its top at y and returns the y position below the text.
+func drawCenteredText(dst *image.RGBA, face font.Face, c color.Color, text string, maxLineWidth, y int) int {
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+	}
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil() * 6 / 5
+
+	for _, line := range wrapText(face, text, maxLineWidth) {
+		lineWidth := font.MeasureString(face, line).Ceil()
+
+		drawer.Dot = fixed.P((dst.Bounds().Dx()-lineWidth)/2, y+metrics.Ascent.Ceil())
+		drawer.DrawString(line)
+
+		y += lineHeight
+	}
+
+	return y
+}
+
+func wrapText(face font.Face, text string, maxLineWidth int) (lines []string) {
+	var line string
+
+	for _, word := range strings.Fields(text) {
+		candidate := word
+
+		if line != "" {
+			candidate = line + " " + word
+		}
+
+		if line != "" && font.MeasureString(face, candidate).Ceil() > maxLineWidth {
+			lines = append(lines, line)
+			line = word
+		} else {
+			line = candidate
+		}
+	}
+
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	return
+}
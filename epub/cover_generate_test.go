@@ -0,0 +1,82 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+)
+
+func TestGeneratedCoverImageSize(t *testing.T) {
+	tests := []struct {
+		cover         CoverOptions
+		width, height int
+		warning       bool
+	}{
+		{CoverOptions{}, 1600, 2560, false},
+		{CoverOptions{MaxWidth: 1000, AspectRatio: "2:3"}, 1000, 1500, false},
+		{CoverOptions{MaxWidth: 4096, MaxHeight: 4096}, 4096, 4096, false},
+		{CoverOptions{MaxWidth: 100000, MaxHeight: 160000}, 2560, 4096, true},
+		{CoverOptions{MaxWidth: 20000}, 4096, 2560 * 4096 / 20000, true},
+		{CoverOptions{MaxWidth: 3000, AspectRatio: "1:100"}, 41, 4096, true},
+		{CoverOptions{MaxWidth: 1, AspectRatio: "2:1"}, 1, 1, false},
+	}
+
+	for _, test := range tests {
+		ei := &epubInfo{Options: Options{Cover: test.cover}}
+
+		width, height, err := ei.generatedCoverImageSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if width != test.width || height != test.height {
+			t.Errorf("%+v: got %dx%d, want %dx%d", test.cover, width, height, test.width, test.height)
+		}
+
+		if warned := len(Diagnostics(ei.output.diagnostics).Warnings()) > 0; warned != test.warning {
+			t.Errorf("%+v: warned %t, want %t", test.cover, warned, test.warning)
+		}
+	}
+}
+
+func TestNewFittedCoverFontFace(t *testing.T) {
+	const size, maxLineWidth = 160, 1280
+
+	tests := []struct {
+		text   string
+		shrunk bool
+	}{
+		{"A Short Title", false},
+		{"A Title With Many Words That Wrap Onto Several Lines", false},
+		{"Pneumonoultramicroscopicsilicovolcanoconiosis", true},
+		{"On " + strings.Repeat("W", 40), true},
+		{"", false},
+	}
+
+	full, err := newCoverFontFace(gobold.TTF, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer full.Close()
+
+	for _, test := range tests {
+		face, err := newFittedCoverFontFace(gobold.TTF, size, test.text, maxLineWidth)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, word := range strings.Fields(test.text) {
+			if width := font.MeasureString(face, word).Ceil(); width > maxLineWidth {
+				t.Errorf("%q: %q is %d pixels wide, more than %d", test.text, word, width, maxLineWidth)
+			}
+		}
+
+		if shrunk := face.Metrics().Height < full.Metrics().Height; shrunk != test.shrunk {
+			t.Errorf("%q: shrunk %t, want %t", test.text, shrunk, test.shrunk)
+		}
+
+		face.Close()
+	}
+}
@@ -0,0 +1,30 @@
+package epub
+
+import (
+	"testing"
+)
+
+func TestCoverSizeWarning(t *testing.T) {
+	kindle := coverPresets["kindle"]
+
+	tests := []struct {
+		width, height int
+		options       CoverOptions
+		want          string
+	}{
+		{1600, 2560, kindle, ""},
+		{2000, 3000, kindle, ""},
+		{1000, 3000, kindle, "cover image is 1000x3000, narrower than the recommended 1600 pixels"},
+		{2000, 2000, kindle, "cover image is 2000x2000, shorter than the recommended 2560 pixels"},
+		{60, 90, kindle, "cover image is 60x90, narrower than the recommended 1600 pixels and shorter than the recommended 2560 pixels"},
+		{100, 100, CoverOptions{MaxWidth: 800}, "cover image is 100x100, narrower than the recommended 800 pixels"},
+		{100, 100, CoverOptions{MaxHeight: 50}, ""},
+		{100, 100, CoverOptions{}, ""},
+	}
+
+	for _, test := range tests {
+		if got := coverSizeWarning(test.width, test.height, test.options); got != test.want {
+			t.Errorf("coverSizeWarning(%d, %d, %+v) = %q, want %q", test.width, test.height, test.options, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package epub
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	hash "github.com/theTardigrade/golang-hash"
+)
+
+type epubInfoOutputStylesheet struct {
+	id      string
+	path    string
+	hash    string
+	content []byte
+}
+
+var (
+	cssCommentRegexp   = regexp.MustCompile(`/\*[\s\S]*?\*/`)
+	cssCharsetRegexp   = regexp.MustCompile(`@charset\s+["'][^"']*["']\s*;`)
+	cssReferenceRegexp = regexp.MustCompile(`@import\s+(?:url\(\s*)?(?:"([^"]*)"|'([^']*)'|([^\s"');]+))\s*\)?\s*([^;]*);|url\(\s*(?:"([^"]*)"|'([^']*)'|([^\s"')]*))\s*\)`)
+)
+
+func epubInfoOutputInitStyles(ei *epubInfo) (err error) {
+	paths := ei.Paths.Stylesheets
+
+	if ei.Paths.Styles != "" {
+		paths = append([]string{ei.Paths.Styles}, paths...)
+	}
+
+	for _, path := range paths {
+		path = ei.resolvePath(path)
+
+		var stylesheet *epubInfoOutputStylesheet
+
+		if stylesheet, err = ei.readStylesheet(path, ""); err != nil {
+			ei.fail(path, 0, "cannot read stylesheet", err)
+			err = nil
+
+			continue
+		}
+
+		ei.output.styles = appendStylesheets(ei.output.styles, stylesheet)
+	}
+
+	return
+}
+
+// readStylesheet processes the stylesheet at path and adds it to the book,
+// restricted to the given media query if one is given.
+func (ei *epubInfo) readStylesheet(path, media string) (stylesheet *epubInfoOutputStylesheet, err error) {
+	b, err := ei.processStylesheet(path, make(map[string]bool))
+	if err != nil {
+		return
+	}
+
+	return ei.addStylesheet(b, media)
+}
+
+// addStylesheet adds processed CSS to the book, unless a stylesheet with
+// the same content has already been added, in which case that is returned.
+func (ei *epubInfo) addStylesheet(b []byte, media string) (stylesheet *epubInfoOutputStylesheet, err error) {
+	if media = strings.TrimSpace(media); media != "" && media != "all" {
+		b = []byte("@media " + media + "{" + string(b) + "}")
+	}
+
+	b, err = minifier.Bytes("text/css", b)
+	if err != nil {
+		return
+	}
+
+	hash := hash.Uint256(b).Text(62)
+
+	for _, stylesheet = range ei.output.stylesheets {
+		if stylesheet.hash == hash {
+			return
+		}
+	}
+
+	number := strconv.Itoa(len(ei.output.stylesheets) + 1)
+
+	stylesheet = &epubInfoOutputStylesheet{
+		id:      "styles_" + number,
+		path:    "styles_" + number + ".css",
+		hash:    hash,
+		content: b,
+	}
+
+	ei.output.stylesheets = append(ei.output.stylesheets, stylesheet)
+
+	return
+}
+
+// extractStylesheets removes the style and stylesheet link elements from an
+// HTML document read from path and returns the stylesheets that they define.
+func (ei *epubInfo) extractStylesheets(doc *goquery.Document, path string) (stylesheets []*epubInfoOutputStylesheet, err error) {
+	doc.Find(`style, link[rel~="stylesheet"]`).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		var stylesheet *epubInfoOutputStylesheet
+
+		media := s.AttrOr("media", "")
+
+		if goquery.NodeName(s) == "style" {
+			b := ei.processStylesheetContent([]byte(s.Text()), path, make(map[string]bool))
+
+			stylesheet, err = ei.addStylesheet(b, media)
+		} else {
+			href := s.AttrOr("href", "")
+
+			if isExternalReference(href) {
+				ei.warn(path, fileLine(path, href), "ignoring remote stylesheet: "+href)
+				s.Remove()
+
+				return true
+			}
+
+			var readErr error
+
+			if stylesheet, readErr = ei.readStylesheet(resolveReference(filepath.Dir(path), href), media); readErr != nil {
+				ei.fail(path, fileLine(path, href), "cannot read stylesheet "+href, readErr)
+				s.Remove()
+
+				return true
+			}
+		}
+		if err != nil {
+			return false
+		}
+
+		stylesheets = appendStylesheets(stylesheets, stylesheet)
+		s.Remove()
+
+		return true
+	})
+
+	return
+}
+
+// stylesheetPaths returns the paths of the stylesheets linked from every
+// page, followed by those of the given page-specific stylesheets.
+func (ei *epubInfo) stylesheetPaths(stylesheets ...*epubInfoOutputStylesheet) (paths []string) {
+	all := appendStylesheets(nil, ei.output.styles...)
+	all = appendStylesheets(all, stylesheets...)
+
+	for _, stylesheet := range all {
+		paths = append(paths, stylesheet.path)
+	}
+
+	return
+}
+
+func appendStylesheets(list []*epubInfoOutputStylesheet, stylesheets ...*epubInfoOutputStylesheet) []*epubInfoOutputStylesheet {
+	for _, stylesheet := range stylesheets {
+		var found bool
+
+		for _, existing := range list {
+			if existing == stylesheet {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			list = append(list, stylesheet)
+		}
+	}
+
+	return list
+}
+
+// processStylesheet reads the stylesheet at path, adds every file that it
+// refers to with url() to the book and inlines the stylesheets that it
+// imports, resolving each reference relative to the stylesheet's directory.
+func (ei *epubInfo) processStylesheet(path string, importing map[string]bool) (b []byte, err error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+
+	if importing[absPath] {
+		return nil, errors.New("stylesheet imports itself: " + path)
+	}
+
+	importing[absPath] = true
+	defer delete(importing, absPath)
+
+	ei.addSource(path)
+
+	b, err = os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	return ei.processStylesheetContent(b, path, importing), nil
+}
+
+// processStylesheetContent processes CSS as processStylesheet does,
+// for CSS read from path, which may be a stylesheet or an HTML document.
+// References that cannot be followed are recorded as diagnostics.
+func (ei *epubInfo) processStylesheetContent(content []byte, path string, importing map[string]bool) (b []byte) {
+	b = cssCommentRegexp.ReplaceAll(content, nil)
+	b = cssCharsetRegexp.ReplaceAll(b, nil)
+
+	dir := filepath.Dir(path)
+
+	b = cssReferenceRegexp.ReplaceAllFunc(b, func(match []byte) []byte {
+		submatches := cssReferenceRegexp.FindSubmatch(match)
+
+		if bytes.HasPrefix(match, []byte("@import")) {
+			ref := string(bytes.Join(submatches[1:4], nil))
+
+			if isExternalReference(ref) {
+				ei.warn(path, fileLine(path, ref), "ignoring remote stylesheet: "+ref)
+
+				return nil
+			}
+
+			imported, importErr := ei.processStylesheet(resolveReference(dir, ref), importing)
+			if importErr != nil {
+				ei.fail(path, fileLine(path, ref), "cannot import stylesheet "+ref, importErr)
+
+				return nil
+			}
+
+			if media := bytes.TrimSpace(submatches[4]); len(media) > 0 {
+				return []byte("@media " + string(media) + "{" + string(imported) + "}")
+			}
+
+			return imported
+		}
+
+		ref := string(bytes.Join(submatches[5:8], nil))
+
+		if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "data:") {
+			return match
+		}
+
+		if isExternalReference(ref) {
+			ei.warn(path, fileLine(path, ref), "remote resource will not be embedded: "+ref)
+
+			return match
+		}
+
+		var fragment string
+
+		if i := strings.IndexByte(ref, '#'); i >= 0 {
+			ref, fragment = ref[:i], ref[i:]
+		}
+
+		if i := strings.IndexByte(ref, '?'); i >= 0 {
+			ref = ref[:i]
+		}
+
+		datum, datumErr := ei.findFileDatum(resolveReference(dir, ref))
+		if datumErr != nil {
+			ei.fail(path, fileLine(path, ref), "cannot read file "+ref, datumErr)
+
+			return match
+		}
+
+		return []byte(`url("` + datum.path + fragment + `")`)
+	})
+
+	return
+}
+
+func isExternalReference(ref string) bool {
+	return strings.HasPrefix(ref, "//") || strings.Contains(ref, "://")
+}
+
+func resolveReference(dir, ref string) string {
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		ref = unescaped
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(ref))
+}
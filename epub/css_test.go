@@ -0,0 +1,112 @@
+package epub
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestStylesheetReferences(t *testing.T) {
+	var image bytes.Buffer
+
+	if err := png.Encode(&image, testImage()); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"text.html": `<html><head>` +
+			`<link rel="stylesheet" href="styles/chapter.css">` +
+			`<style>.inline{background:url('images/dot.png')}</style>` +
+			`</head><body><h1>One</h1><p class="inline">Text.</p></body></html>`,
+		"styles/main.css":        `@import "parts/print.css" print; body{background:url("../images/dot.png#x")}`,
+		"styles/parts/print.css": `/* a comment with url(missing.png) */ .print{background-image:url(../../images/dot.png?v=2)}`,
+		"styles/chapter.css":     `@charset "utf-8"; .chapter{background:url(https://example.com/remote.png)}`,
+		"images/dot.png":         image.String(),
+	}
+
+	for _, version := range []int{2, 3} {
+		options := Options{
+			EPUBVersion: version,
+			Title:       "Styles",
+		}
+
+		options.Paths.Text = "text.html"
+		options.Paths.Styles = "styles/main.css"
+
+		r := buildTestBook(t, files, options)
+
+		problems, err := ValidateReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, problem := range problems {
+			t.Errorf("EPUB %d: %s", version, problem)
+		}
+
+		main := readTestArchiveFile(t, r, "styles_1.css")
+
+		// every reference to the image should point at the same embedded copy
+		refs := regexp.MustCompile(`url\("?(files/[^"#)]+\.png)(#[^")]*)?"?\)`).FindAllStringSubmatch(main, -1)
+
+		if len(refs) != 2 {
+			t.Fatalf("EPUB %d: want 2 rewritten references in styles_1.css, got %q", version, main)
+		}
+
+		if refs[0][1] != refs[1][1] {
+			t.Errorf("EPUB %d: references point at different files: %s and %s", version, refs[0][1], refs[1][1])
+		}
+
+		if readTestArchiveFile(t, r, refs[0][1]) != image.String() {
+			t.Errorf("EPUB %d: %s does not contain the image", version, refs[0][1])
+		}
+
+		for _, want := range []string{"@media print{", ".print{", "#x"} {
+			if !strings.Contains(main, want) {
+				t.Errorf("EPUB %d: styles_1.css does not contain %s: %q", version, want, main)
+			}
+		}
+
+		for _, unwanted := range []string{"@import", "comment", "missing.png", "?v=2"} {
+			if strings.Contains(main, unwanted) {
+				t.Errorf("EPUB %d: styles_1.css contains %s: %q", version, unwanted, main)
+			}
+		}
+
+		var chapterStyles, inlineStyles string
+
+		for _, f := range r.File {
+			if !strings.HasSuffix(f.Name, ".css") {
+				continue
+			}
+
+			switch content := readTestArchiveFile(t, r, f.Name); {
+			case strings.Contains(content, ".chapter"):
+				chapterStyles = content
+			case strings.Contains(content, ".inline"):
+				inlineStyles = content
+			}
+		}
+
+		if !strings.Contains(chapterStyles, "https://example.com/remote.png") || strings.Contains(chapterStyles, "@charset") {
+			t.Errorf("EPUB %d: unexpected chapter stylesheet %q", version, chapterStyles)
+		}
+
+		if !strings.Contains(inlineStyles, refs[0][1]) {
+			t.Errorf("EPUB %d: style element does not refer to %s: %q", version, refs[0][1], inlineStyles)
+		}
+
+		chapter := readTestArchiveFile(t, r, "chapter_1.xhtml")
+
+		if strings.Contains(chapter, ".inline") || strings.Contains(chapter, "styles/chapter.css") {
+			t.Errorf("EPUB %d: chapter_1.xhtml still has its own stylesheets: %q", version, chapter)
+		}
+	}
+}
+
+func testImage() image.Image {
+	return image.NewGray(image.Rect(0, 0, 2, 2))
+}
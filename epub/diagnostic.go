@@ -0,0 +1,198 @@
+package epub
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// Severity describes how serious a Diagnostic is.
+type Severity int
+
+const (
+	// SeverityWarning marks a problem that does not prevent the book
+	// from being built.
+	SeverityWarning Severity = iota
+	// SeverityError marks a problem that prevents the book from being built.
+	SeverityError
+)
+
+func (severity Severity) String() string {
+	if severity == SeverityError {
+		return "error"
+	}
+
+	return "warning"
+}
+
+// MarshalText encodes the severity as its name.
+func (severity Severity) MarshalText() ([]byte, error) {
+	return []byte(severity.String()), nil
+}
+
+// Diagnostic describes a single problem found while building a book,
+// along with the source file, and line within it, that it concerns.
+// The path and line are omitted when they are not known.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Message  string   `json:"message"`
+
+	err error
+}
+
+func (diagnostic Diagnostic) String() string {
+	var location string
+
+	if diagnostic.Path != "" {
+		location = diagnostic.Path + ":"
+
+		if diagnostic.Line > 0 {
+			location += strconv.Itoa(diagnostic.Line) + ":"
+		}
+
+		location += " "
+	}
+
+	return location + diagnostic.Severity.String() + ": " + diagnostic.Message
+}
+
+// Diagnostics is a list of problems. It is returned as an error by Build,
+// holding every error found, when one or more of them prevent the book
+// from being built.
+type Diagnostics []Diagnostic
+
+func (diagnostics Diagnostics) Error() string {
+	errs := diagnostics.Errors()
+
+	switch len(errs) {
+	case 0:
+		return "no errors"
+	case 1:
+		return errs[0].String()
+	}
+
+	return errs[0].String() + " (and " + strconv.Itoa(len(errs)-1) + " more errors)"
+}
+
+// Unwrap returns the underlying errors, if any, from which the
+// diagnostics were created.
+func (diagnostics Diagnostics) Unwrap() (errs []error) {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.err != nil {
+			errs = append(errs, diagnostic.err)
+		}
+	}
+
+	return
+}
+
+// Errors returns the diagnostics with error severity.
+func (diagnostics Diagnostics) Errors() Diagnostics {
+	return diagnostics.filter(SeverityError)
+}
+
+// Warnings returns the diagnostics with warning severity.
+func (diagnostics Diagnostics) Warnings() Diagnostics {
+	return diagnostics.filter(SeverityWarning)
+}
+
+func (diagnostics Diagnostics) filter(severity Severity) (filtered Diagnostics) {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == severity {
+			filtered = append(filtered, diagnostic)
+		}
+	}
+
+	return
+}
+
+func (ei *epubInfo) warn(path string, line int, message string) {
+	ei.output.diagnostics = append(ei.output.diagnostics, Diagnostic{
+		Severity: SeverityWarning,
+		Path:     path,
+		Line:     line,
+		Message:  message,
+	})
+}
+
+// fail records an error that prevents the book from being built, but that
+// does not prevent the rest of its sources being checked for problems.
+func (ei *epubInfo) fail(path string, line int, message string, err error) {
+	if err != nil {
+		message += ": " + errorMessage(err)
+	}
+
+	ei.output.diagnostics = append(ei.output.diagnostics, Diagnostic{
+		Severity: SeverityError,
+		Path:     path,
+		Line:     line,
+		Message:  message,
+		err:      err,
+	})
+}
+
+// failOption records an error in the options, at the first line of the
+// options file on which s, usually the quoted value or name of the option,
+// is found.
+func (ei *epubInfo) failOption(s, message string) {
+	ei.fail(ei.OptionsPath, fileLine(ei.OptionsPath, s), message, nil)
+}
+
+func (ei *epubInfo) hasErrors() bool {
+	return len(Diagnostics(ei.output.diagnostics).Errors()) > 0
+}
+
+// newErrorDiagnostic describes an error that stopped a book from being
+// built, taking its path from the error where possible.
+func newErrorDiagnostic(err error) Diagnostic {
+	diagnostic := Diagnostic{
+		Severity: SeverityError,
+		Message:  err.Error(),
+		err:      err,
+	}
+
+	var pathErr *fs.PathError
+
+	if errors.As(err, &pathErr) && pathErr == err {
+		diagnostic.Path = pathErr.Path
+		diagnostic.Message = "cannot " + pathErr.Op + ": " + pathErr.Err.Error()
+	}
+
+	return diagnostic
+}
+
+// errorMessage returns the message of err without the path that
+// it may repeat.
+func errorMessage(err error) string {
+	var pathErr *fs.PathError
+
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+
+	return err.Error()
+}
+
+// fileLine returns the number of the first line of the file at path
+// on which s is found, or 0 if it is not found.
+func fileLine(path, s string) int {
+	if s == "" {
+		return 0
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	i := bytes.Index(b, []byte(s))
+	if i < 0 {
+		return 0
+	}
+
+	return bytes.Count(b[:i], []byte("\n")) + 1
+}
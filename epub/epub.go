@@ -0,0 +1,941 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/iancoleman/strcase"
+	hash "github.com/theTardigrade/golang-hash"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+const (
+	OptionsFileExt  = ".json"
+	OptionsFileName = "epub_info" + OptionsFileExt
+)
+
+// Options describes a book and the source files it is generated from.
+// It is usually decoded from an epub_info.json file.
+//
+// TextLanguages and TextStylesheets are keyed by the paths of texts relative
+// to the base directory, and apply to every chapter that a text is split
+// into; a chapter cannot be given a language or stylesheet of its own.
+type Options struct {
+	EPUBVersion              int                 `json:"epub_version"`
+	Language                 string              `json:"language"`
+	Direction                string              `json:"direction"`
+	TextLanguages            map[string]string   `json:"text_languages"`
+	TextStylesheets          map[string][]string `json:"text_stylesheets"`
+	MaxHeadingDepth          int                 `json:"max_heading_depth"`
+	ISBN                     string              `json:"isbn"`
+	CoverImageFormat         string              `json:"cover_image_format"`
+	Cover                    CoverOptions        `json:"cover"`
+	Identifiers              []Identifier        `json:"identifiers"`
+	Title                    string              `json:"title"`
+	Author                   string              `json:"author"`
+	AuthorFileAs             string              `json:"author_file_as"`
+	EditionNumber            int                 `json:"edition_number"`
+	Publisher                string              `json:"publisher"`
+	PublicationDate          string              `json:"publication_date"`
+	Description              string              `json:"description"`
+	Subjects                 []string            `json:"subjects"`
+	Rights                   string              `json:"rights"`
+	Series                   Series              `json:"series"`
+	Contributors             []Contributor       `json:"contributors"`
+	Files                    []string            `json:"files"`
+	Fonts                    []Font              `json:"fonts"`
+	Images                   ImageOptions        `json:"images"`
+	IncludeContentsPage      bool                `json:"include_contents_page"`
+	IncludeCopyrightPage     bool                `json:"include_copyright_page"`
+	ShouldCapitalizeHeadings bool                `json:"should_capitalize_headings"`
+	ShouldGenerateCover      bool                `json:"should_generate_cover"`
+	ShouldSplitTextFiles     bool                `json:"should_split_text_files"`
+	OutputPath               string              `json:"output_path"`
+	// BaseDirectory is the directory against which relative paths are
+	// resolved. LoadOptions sets it to the directory of the options file.
+	BaseDirectory string `json:"-"`
+	// OptionsPath is the path of the options file, against which problems
+	// with the options are reported. LoadOptions sets it.
+	OptionsPath string `json:"-"`
+	Paths       struct {
+		CoverImage  string   `json:"cover_image"`
+		Styles      string   `json:"styles"`
+		Stylesheets []string `json:"stylesheets"`
+		Text        string   `json:"text"`
+		Texts       []string `json:"texts"`
+
+		TitleTemplate     string `json:"title_template"`
+		CopyrightTemplate string `json:"copyright_template"`
+		ContentsTemplate  string `json:"contents_template"`
+	} `json:"paths"`
+}
+
+type epubInfo struct {
+	Options
+
+	cache *buildCache
+
+	output struct {
+		coverImage         *epubInfoOutputCoverImage
+		diagnostics        []Diagnostic
+		sources            []string
+		styles             []*epubInfoOutputStylesheet
+		stylesheets        []*epubInfoOutputStylesheet
+		textPaths          []string
+		texts              [][]byte
+		textLanguages      []language.Tag
+		textStylesheets    [][]*epubInfoOutputStylesheet
+		identifiers        []Identifier
+		uniqueIdentifier   string
+		language           language.Tag
+		direction          string
+		textHeadings       []*epubInfoOutputTextHeading
+		textHeadingsTree   []*epubInfoOutputTextHeading
+		textChapters       []*epubInfoOutputTextChapter
+		titleSnaked        string
+		fileData           []*epubInfoOutputFileDatum
+		fonts              []*epubInfoOutputFont
+		imageOptimizations []ImageOptimization
+		fontSubsets        []FontSubset
+		modified           time.Time
+		titleTemplate      *template.Template
+		copyrightTemplate  *template.Template
+		contentsTemplate   *template.Template
+	}
+}
+
+type epubInfoOutputFileDatum struct {
+	hash       string
+	path       string
+	sourcePath string
+	ext        string
+	mimeType   string
+	content    []byte
+	obfuscated bool
+}
+
+type epubInfoOutputTextHeading struct {
+	text     string
+	id       string
+	path     string
+	level    int
+	children []*epubInfoOutputTextHeading
+}
+
+func (heading *epubInfoOutputTextHeading) href() string {
+	return heading.path + "#" + heading.id
+}
+
+type epubInfoOutputTextChapter struct {
+	id          string
+	path        string
+	title       string
+	language    language.Tag
+	direction   string
+	stylesheets []*epubInfoOutputStylesheet
+	content     []byte
+}
+
+type epubInfoOutputInitHandler = func(*epubInfo) error
+
+// errDiagnosed is returned by init handlers that cannot continue because of
+// errors that they have already recorded as diagnostics.
+var errDiagnosed = errors.New("errors found in sources")
+
+var (
+	epubInfoOutputInitHandlerList = []epubInfoOutputInitHandler{
+		epubInfoOutputInitVersion,
+		epubInfoOutputInitMetadata,
+		epubInfoOutputInitIdentifiers,
+		epubInfoOutputInitLanguage,
+		epubInfoOutputInitCoverImage,
+		epubInfoOutputInitFonts,
+		epubInfoOutputInitStyles,
+		epubInfoOutputInitTextPaths,
+		epubInfoOutputInitText,
+		epubInfoOutputInitTextHeadings,
+		epubInfoOutputInitTextChapters,
+		epubInfoOutputInitOutputTitle,
+		epubInfoOutputInitFiles,
+		epubInfoOutputInitFileOptimization,
+		epubInfoOutputInitTemplates,
+		epubInfoOutputInitFontSubsetting,
+	}
+)
+
+func (ei *epubInfo) isEPUB3() bool {
+	return ei.EPUBVersion >= 3
+}
+
+func epubInfoOutputInit(ctx context.Context, ei *epubInfo) (err error) {
+	for _, handler := range epubInfoOutputInitHandlerList {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		if err = handler(ei); err != nil {
+			if err != errDiagnosed {
+				ei.output.diagnostics = append(ei.output.diagnostics, newErrorDiagnostic(err))
+			}
+
+			break
+		}
+	}
+
+	if ei.hasErrors() {
+		return Diagnostics(ei.output.diagnostics).Errors()
+	}
+
+	return
+}
+
+func epubInfoOutputInitVersion(ei *epubInfo) (err error) {
+	switch ei.EPUBVersion {
+	case 0:
+		ei.EPUBVersion = 2
+	case 2, 3:
+	default:
+		return errors.New("unsupported EPUB version: " + strconv.Itoa(ei.EPUBVersion))
+	}
+
+	ei.output.modified = time.Now().UTC()
+
+	return
+}
+
+var (
+	epubInfoOutputInitTextExts = map[string]struct{}{
+		".md":    {},
+		".html":  {},
+		".xhtml": {},
+	}
+)
+
+func epubInfoOutputInitTextPaths(ei *epubInfo) (err error) {
+	var patterns []string
+
+	if ei.Paths.Text != "" {
+		patterns = append(patterns, ei.Paths.Text)
+	}
+
+	patterns = append(patterns, ei.Paths.Texts...)
+
+	for _, pattern := range patterns {
+		pattern = ei.resolvePath(pattern)
+
+		// a directory is watched for texts being added to it or removed
+		if dir := filepath.Dir(pattern); strings.ContainsAny(pattern, "*?[") && !strings.ContainsAny(dir, "*?[") {
+			ei.addSource(dir)
+		} else {
+			ei.addSource(pattern)
+		}
+
+		paths, pathsErr := textPathsFromPattern(pattern)
+		if pathsErr != nil {
+			ei.fail(pattern, 0, "cannot find texts", pathsErr)
+			continue
+		}
+
+		ei.output.textPaths = append(ei.output.textPaths, paths...)
+	}
+
+	if len(ei.output.textPaths) == 0 {
+		if ei.hasErrors() {
+			return errDiagnosed
+		}
+
+		return errors.New("no text files found")
+	}
+
+	return
+}
+
+func textPathsFromPattern(pattern string) (paths []string, err error) {
+	if strings.ContainsAny(pattern, "*?[") {
+		var matches []string
+
+		matches, err = filepath.Glob(pattern)
+		if err != nil {
+			return
+		}
+
+		if len(matches) == 0 {
+			err = errors.New("no text files match pattern: " + pattern)
+			return
+		}
+
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			var matchPaths []string
+
+			matchPaths, err = textPathsFromPattern(match)
+			if err != nil {
+				return
+			}
+
+			paths = append(paths, matchPaths...)
+		}
+
+		return
+	}
+
+	info, err := os.Stat(pattern)
+	if err != nil {
+		return
+	}
+
+	if !info.IsDir() {
+		paths = append(paths, pattern)
+		return
+	}
+
+	entries, err := os.ReadDir(pattern)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if _, ok := epubInfoOutputInitTextExts[filepath.Ext(entry.Name())]; !ok {
+			continue
+		}
+
+		paths = append(paths, filepath.Join(pattern, entry.Name()))
+	}
+
+	return
+}
+
+func epubInfoOutputInitText(ei *epubInfo) (err error) {
+	for _, path := range ei.output.textPaths {
+		var b []byte
+		var lang string
+		var stylesheets []*epubInfoOutputStylesheet
+
+		b, lang, stylesheets, err = ei.readTextFile(path)
+		if err != nil {
+			ei.fail(path, 0, "cannot read text", err)
+			err = nil
+
+			continue
+		}
+
+		for _, stylesheetPath := range ei.TextStylesheets[ei.textOptionKey(ei.TextStylesheets, path)] {
+			stylesheetPath = ei.resolvePath(stylesheetPath)
+
+			var stylesheet *epubInfoOutputStylesheet
+
+			if stylesheet, err = ei.readStylesheet(stylesheetPath, ""); err != nil {
+				ei.fail(stylesheetPath, 0, "cannot read stylesheet", err)
+				err = nil
+
+				continue
+			}
+
+			stylesheets = appendStylesheets(stylesheets, stylesheet)
+		}
+
+		langKey := ei.textOptionKey(ei.TextLanguages, path)
+
+		if langKey != "" {
+			lang = ei.TextLanguages[langKey]
+		}
+
+		tag := ei.output.language
+
+		if lang != "" {
+			var langErr error
+
+			if tag, langErr = language.Parse(lang); langErr != nil {
+				if langKey != "" {
+					ei.failOption(`"`+langKey+`":`, "invalid language for "+langKey+": "+lang)
+				} else {
+					ei.fail(path, fileLine(path, lang), "invalid language: "+lang, nil)
+				}
+
+				tag = ei.output.language
+			}
+		}
+
+		ei.output.texts = append(ei.output.texts, b)
+		ei.output.textLanguages = append(ei.output.textLanguages, tag)
+		ei.output.textStylesheets = append(ei.output.textStylesheets, stylesheets)
+	}
+
+	ei.warnUnusedTextStylesheets()
+
+	// the remaining steps need at least one text to work with
+	if len(ei.output.texts) == 0 {
+		return errDiagnosed
+	}
+
+	return
+}
+
+func (ei *epubInfo) readTextFile(path string) (b []byte, lang string, stylesheets []*epubInfoOutputStylesheet, err error) {
+	ei.addSource(path)
+
+	b, err = os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	switch filepath.Ext(path) {
+	case ".md":
+		// the rendered text is reused while its source is unchanged
+		key := "markdown_" + ei.sourceDigest(path, b)
+
+		if rendered, ok := ei.cache.load(key); ok {
+			return rendered, "", nil, nil
+		}
+
+		defer func() {
+			if err == nil {
+				ei.cache.store(key, b)
+			}
+		}()
+
+		p := parser.New()
+
+		document := p.Parse(b)
+		renderer := html.NewRenderer(html.RendererOptions{
+			Flags: html.CommonFlags,
+		})
+
+		b = markdown.Render(document, renderer)
+	case ".html", ".xhtml":
+		r := bytes.NewReader(b)
+
+		var doc *goquery.Document
+
+		doc, err = goquery.NewDocumentFromReader(r)
+		if err != nil {
+			return
+		}
+
+		if stylesheets, err = ei.extractStylesheets(doc, path); err != nil {
+			return
+		}
+
+		var docString string
+
+		docString, err = doc.Find("body").Html()
+		if err != nil {
+			return
+		}
+
+		b = []byte(docString)
+
+		for _, selector := range []string{"body", "html"} {
+			s := doc.Find(selector)
+
+			if lang = s.AttrOr("lang", s.AttrOr("xml:lang", "")); lang != "" {
+				break
+			}
+		}
+	default:
+		err = errors.New("unrecognized text file extension: " + path)
+		return
+	}
+
+	b, err = minifier.Bytes("text/xml", b)
+	if err != nil {
+		return
+	}
+
+	b = xmlSanitize(b)
+
+	return
+}
+
+func epubInfoOutputInitTextHeadings(ei *epubInfo) (err error) {
+	switch {
+	case ei.MaxHeadingDepth == 0:
+		ei.MaxHeadingDepth = 1
+	case ei.MaxHeadingDepth < 1 || ei.MaxHeadingDepth > 6:
+		ei.failOption(`"max_heading_depth"`, "maximum heading depth must be between 1 and 6: "+strconv.Itoa(ei.MaxHeadingDepth))
+		ei.MaxHeadingDepth = 1
+	}
+
+	for i, text := range ei.output.texts {
+		var caser cases.Caser
+
+		if ei.ShouldCapitalizeHeadings {
+			caser = cases.Title(ei.output.textLanguages[i])
+		}
+
+		if ei.output.texts[i], err = ei.processTextHeadings(text, ei.output.textPaths[i], caser); err != nil {
+			return
+		}
+	}
+
+	var parents []*epubInfoOutputTextHeading
+
+	for _, heading := range ei.output.textHeadings {
+		for len(parents) > 0 && parents[len(parents)-1].level >= heading.level {
+			parents = parents[:len(parents)-1]
+		}
+
+		if len(parents) == 0 {
+			ei.output.textHeadingsTree = append(ei.output.textHeadingsTree, heading)
+		} else {
+			parent := parents[len(parents)-1]
+			parent.children = append(parent.children, heading)
+		}
+
+		parents = append(parents, heading)
+	}
+
+	return
+}
+
+// processTextHeadings assigns identifiers to the headings of a text read
+// from path, removes the attributes that its EPUB version does not allow
+// and adds the images that it refers to to the book.
+func (ei *epubInfo) processTextHeadings(text []byte, path string, caser cases.Caser) (b []byte, err error) {
+	r := bytes.NewReader(text)
+
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return
+	}
+
+	doc.Find("h1,h2,h3,h4,h5,h6").Each(func(i int, s *goquery.Selection) {
+		heading := s.Text()
+
+		if ei.ShouldCapitalizeHeadings {
+			heading = caser.String(heading)
+
+			s.SetText(heading)
+		}
+
+		level := int(goquery.NodeName(s)[1] - '0')
+
+		if level <= ei.MaxHeadingDepth {
+			// an id written by the author is kept, so that links to it still
+			// work, unless another heading already has it
+			id := strings.TrimSpace(s.AttrOr("id", ""))
+
+			if id == "" || ei.hasTextHeadingID(id) {
+				id = "epub_generator_text_heading_" + strconv.Itoa(len(ei.output.textHeadings)+1)
+			}
+
+			ei.output.textHeadings = append(ei.output.textHeadings, &epubInfoOutputTextHeading{
+				text:  heading,
+				id:    id,
+				level: level,
+			})
+
+			s.SetAttr("id", id)
+		}
+	})
+
+	// attributes such as epub:type would be in an undeclared namespace
+	// in an EPUB 2 book
+	if !ei.isEPUB3() {
+		doc.Find("*").Each(func(i int, s *goquery.Selection) {
+			var keys []string
+
+			for _, attr := range s.Nodes[0].Attr {
+				if strings.HasPrefix(attr.Key, "epub:") {
+					keys = append(keys, attr.Key)
+				}
+			}
+
+			for _, key := range keys {
+				s.RemoveAttr(key)
+			}
+		})
+	}
+
+	doc.Find("img").Each(func(i int, s *goquery.Selection) {
+		src, srcExists := s.Attr("src")
+		if !srcExists || strings.HasPrefix(src, "data:") {
+			return
+		}
+
+		if isExternalReference(src) {
+			ei.warn(path, fileLine(path, src), "remote image will not be embedded: "+src)
+			return
+		}
+
+		datum, datumErr := ei.findFileDatum(resolveReference(filepath.Dir(path), src))
+		if datumErr != nil {
+			ei.fail(path, fileLine(path, src), "cannot read image "+src, datumErr)
+			return
+		}
+
+		s.SetAttr("src", datum.path)
+	})
+
+	docString, err := doc.Find("body").Html()
+	if err != nil {
+		return
+	}
+
+	b = []byte(docString)
+
+	return
+}
+
+func (ei *epubInfo) hasTextHeadingID(id string) bool {
+	for _, heading := range ei.output.textHeadings {
+		if heading.id == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func epubInfoOutputInitTextChapters(ei *epubInfo) (err error) {
+	headingsByID := make(map[string]*epubInfoOutputTextHeading, len(ei.output.textHeadings))
+
+	for _, heading := range ei.output.textHeadings {
+		headingsByID[heading.id] = heading
+	}
+
+	var chapter *epubInfoOutputTextChapter
+	var contentBuilder bytes.Buffer
+
+	nextChapter := func(tag language.Tag) {
+		if chapter != nil {
+			chapter.content = append([]byte(nil), contentBuilder.Bytes()...)
+			contentBuilder.Reset()
+		}
+
+		number := strconv.Itoa(len(ei.output.textChapters) + 1)
+
+		chapter = &epubInfoOutputTextChapter{
+			id:        "chapter_" + number,
+			path:      "chapter_" + number + ".xhtml",
+			title:     "Text",
+			language:  tag,
+			direction: ei.languageDirection(tag),
+		}
+
+		ei.output.textChapters = append(ei.output.textChapters, chapter)
+	}
+
+	nextChapter(ei.output.textLanguages[0])
+
+	var chapterHasHeading bool
+
+	for i, text := range ei.output.texts {
+		textLanguage := ei.output.textLanguages[i]
+		textStylesheets := ei.output.textStylesheets[i]
+
+		// a chapter has a single language, so a text in another language
+		// starts a chapter of its own
+		switch {
+		case contentBuilder.Len() == 0:
+			chapter.language = textLanguage
+			chapter.direction = ei.languageDirection(textLanguage)
+		case ei.ShouldSplitTextFiles || textLanguage != chapter.language:
+			nextChapter(textLanguage)
+
+			chapterHasHeading = false
+		}
+
+		r := bytes.NewReader(text)
+
+		var doc *goquery.Document
+
+		doc, err = goquery.NewDocumentFromReader(r)
+		if err != nil {
+			return
+		}
+
+		doc.Find("body").Contents().EachWithBreak(func(i int, s *goquery.Selection) bool {
+			if s.Is("h1") && contentBuilder.Len() > 0 {
+				nextChapter(textLanguage)
+
+				chapterHasHeading = false
+			}
+
+			s.Find("[id]").AddSelection(s.Filter("[id]")).Each(func(i int, s2 *goquery.Selection) {
+				heading, ok := headingsByID[s2.AttrOr("id", "")]
+				if !ok {
+					return
+				}
+
+				heading.path = chapter.path
+
+				if heading.level == 1 && !chapterHasHeading {
+					chapter.title = heading.text
+					chapterHasHeading = true
+				}
+			})
+
+			var html string
+
+			html, err = goquery.OuterHtml(s)
+			if err != nil {
+				return false
+			}
+
+			contentBuilder.WriteString(html)
+
+			chapter.stylesheets = appendStylesheets(chapter.stylesheets, textStylesheets...)
+
+			return true
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	chapter.content = contentBuilder.Bytes()
+
+	return ei.linkTextChapters()
+}
+
+// linkTextChapters points the links within a text, written as "#id", at
+// the chapter to which the element with that id was split off.
+func (ei *epubInfo) linkTextChapters() (err error) {
+	if len(ei.output.textChapters) < 2 {
+		return
+	}
+
+	docs := make([]*goquery.Document, len(ei.output.textChapters))
+	pathsByID := make(map[string]string)
+
+	for i, chapter := range ei.output.textChapters {
+		if docs[i], err = goquery.NewDocumentFromReader(bytes.NewReader(chapter.content)); err != nil {
+			return
+		}
+
+		docs[i].Find("[id]").Each(func(_ int, s *goquery.Selection) {
+			if id := s.AttrOr("id", ""); pathsByID[id] == "" {
+				pathsByID[id] = chapter.path
+			}
+		})
+	}
+
+	for i, chapter := range ei.output.textChapters {
+		var changed bool
+
+		docs[i].Find(`[href^="#"]`).Each(func(_ int, s *goquery.Selection) {
+			id := strings.TrimPrefix(s.AttrOr("href", ""), "#")
+
+			if path, ok := pathsByID[id]; ok && path != chapter.path {
+				s.SetAttr("href", path+"#"+id)
+				changed = true
+			}
+		})
+
+		if !changed {
+			continue
+		}
+
+		var content string
+
+		if content, err = docs[i].Find("body").Html(); err != nil {
+			return
+		}
+
+		chapter.content = []byte(content)
+	}
+
+	return
+}
+
+func epubInfoOutputInitOutputTitle(ei *epubInfo) (err error) {
+	ei.output.titleSnaked = strcase.ToSnake(ei.Title)
+
+	return
+}
+
+func epubInfoOutputInitFiles(ei *epubInfo) (err error) {
+	for _, f := range ei.Files {
+		f = ei.resolvePath(f)
+
+		if _, fileErr := ei.findFileDatum(f); fileErr != nil {
+			ei.fail(f, 0, "cannot read file", fileErr)
+		}
+	}
+
+	return
+}
+
+// resolvePath returns path resolved against the base directory,
+// unless it is absolute.
+func (options *Options) resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(options.BaseDirectory, path)
+}
+
+// addSource records the path of a file or directory that the book is built
+// from, whether or not it exists, so that changes to it can be watched for.
+func (ei *epubInfo) addSource(path string) {
+	for _, source := range ei.output.sources {
+		if source == path {
+			return
+		}
+	}
+
+	ei.output.sources = append(ei.output.sources, path)
+}
+
+// relativePath returns a resolved path relative to the base directory,
+// as it would be written in the options.
+func (ei *epubInfo) relativePath(path string) string {
+	if relativePath, err := filepath.Rel(filepath.Join(ei.BaseDirectory, "."), path); err == nil {
+		return relativePath
+	}
+
+	return path
+}
+
+// warnUnusedTextStylesheets warns about each key of the text stylesheets
+// that names none of the texts. Stylesheets are given to whole texts, so a
+// key naming a chapter produced by splitting a text is one of these.
+func (ei *epubInfo) warnUnusedTextStylesheets() {
+	var keys []string
+
+	for key := range ei.TextStylesheets {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var found bool
+
+		for _, path := range ei.output.textPaths {
+			if ei.isTextOptionKey(key, path) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			ei.warn(ei.OptionsPath, fileLine(ei.OptionsPath, `"`+key+`":`), "text stylesheets given for a file that is not a text: "+key)
+		}
+	}
+}
+
+// textOptionKey returns the key of options, a map keyed by the paths of
+// texts relative to the base directory, that names the text at path, or an
+// empty string if none does. Keys such as "./a.md" and "b/../a.md" name the
+// same text as "a.md".
+func (ei *epubInfo) textOptionKey(options interface{}, path string) (key string) {
+	var keys []string
+
+	switch options := options.(type) {
+	case map[string]string:
+		for key := range options {
+			keys = append(keys, key)
+		}
+	case map[string][]string:
+		for key := range options {
+			keys = append(keys, key)
+		}
+	}
+
+	// the first key in name order is used if more than one names the text
+	sort.Strings(keys)
+
+	for _, key = range keys {
+		if ei.isTextOptionKey(key, path) {
+			return
+		}
+	}
+
+	return ""
+}
+
+func (ei *epubInfo) isTextOptionKey(key, path string) bool {
+	return ei.relativePath(ei.resolvePath(key)) == ei.relativePath(path)
+}
+
+// sourceDigest hashes content b read from the file at path. Hashing large
+// files is slow, so the digest is reused from an earlier build of the book
+// for as long as the size and modification time of the file are unchanged.
+func (ei *epubInfo) sourceDigest(path string, b []byte) string {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != int64(len(b)) {
+		return hash.Uint256(b).Text(62)
+	}
+
+	key := "digest_" + path + "_" + strconv.FormatInt(info.Size(), 10) + "_" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+
+	if digest, ok := ei.cache.load(key); ok {
+		return string(digest)
+	}
+
+	digest := hash.Uint256(b).Text(62)
+
+	ei.cache.store(key, []byte(digest))
+
+	return digest
+}
+
+func (ei *epubInfo) findFileDatum(path string) (datum *epubInfoOutputFileDatum, err error) {
+	ei.addSource(path)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	ext := filepath.Ext(path)
+	hash := ei.sourceDigest(path, b)
+
+	var foundDatum bool
+
+	for _, datum = range ei.output.fileData {
+		if datum.hash == hash && datum.ext == ext {
+			foundDatum = true
+			break
+		}
+	}
+
+	if foundDatum {
+		return
+	}
+
+	mimeType := ei.fontMediaType(ext)
+
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(ext)
+	}
+
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	datum = &epubInfoOutputFileDatum{
+		hash:       hash,
+		path:       "files/" + hash + ext,
+		sourcePath: path,
+		ext:        ext,
+		mimeType:   mimeType,
+		content:    b,
+	}
+
+	ei.output.fileData = append(ei.output.fileData, datum)
+
+	return
+}
@@ -0,0 +1,316 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSplitTextLinks(t *testing.T) {
+	const text = `<html><body>
+<h1 id="one">One</h1>
+<p>See the note<a href="#note">1</a> and <a href="#two">chapter two</a>.</p>
+<p id="back">Back here.</p>
+<h1 id="two">Two</h1>
+<p>Nothing to see.</p>
+<h1 id="notes">Notes</h1>
+<p id="note">The note. <a href="#back">Return</a>, <a href="#notes">here</a> or <a href="#one">to the start</a>.</p>
+</body></html>`
+
+	for _, version := range []int{2, 3} {
+		r := buildTestBook(t, map[string]string{"text.html": text}, Options{
+			EPUBVersion: version,
+			Title:       "Links",
+		})
+
+		problems, err := ValidateReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, problem := range problems {
+			t.Errorf("EPUB %d: %s", version, problem)
+		}
+
+		chapter1 := readTestArchiveFile(t, r, "chapter_1.xhtml")
+		chapter3 := readTestArchiveFile(t, r, "chapter_3.xhtml")
+
+		for _, want := range []string{`href="chapter_3.xhtml#note"`, `href="chapter_2.xhtml#two"`, `id="one"`, `id="back"`} {
+			if !strings.Contains(chapter1, want) {
+				t.Errorf("EPUB %d: chapter_1.xhtml does not contain %s", version, want)
+			}
+		}
+
+		for _, want := range []string{`href="chapter_1.xhtml#back"`, `href="#notes"`, `href="chapter_1.xhtml#one"`} {
+			if !strings.Contains(chapter3, want) {
+				t.Errorf("EPUB %d: chapter_3.xhtml does not contain %s", version, want)
+			}
+		}
+	}
+}
+
+func TestTextPaths(t *testing.T) {
+	files := map[string]string{
+		"intro.md":             "# Intro\n",
+		"chapters/02.md":       "# Two\n",
+		"chapters/01.md":       "# One\n",
+		"chapters/10.md":       "# Ten\n",
+		"chapters/notes.txt":   "Not a text.\n",
+		"chapters/draft/03.md": "# Draft\n",
+		"appendix/b.html":      "<h1>B</h1>",
+		"appendix/a.xhtml":     "<h1>A</h1>",
+		"appendix/c.md":        "# C\n",
+	}
+
+	options := Options{Title: "Paths"}
+
+	options.Paths.Text = "intro.md"
+	options.Paths.Texts = []string{"chapters", "appendix/*.*html"}
+
+	r := buildTestBook(t, files, options)
+
+	titleRegexp := regexp.MustCompile(`<title>([^<]*)</title>`)
+
+	var titles []string
+
+	for i := 1; ; i++ {
+		name := "chapter_" + strconv.Itoa(i) + ".xhtml"
+
+		if _, err := r.Open(name); err != nil {
+			break
+		}
+
+		if match := titleRegexp.FindStringSubmatch(readTestArchiveFile(t, r, name)); match != nil {
+			titles = append(titles, match[1])
+		}
+	}
+
+	// directories and globs are read in name order, after the main text
+	if want := []string{"Intro", "One", "Two", "Ten", "A", "B"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("got chapters %q, want %q", titles, want)
+	}
+
+	dir := t.TempDir()
+
+	for _, pattern := range []string{"missing/*.md", "missing.md"} {
+		options := Options{Title: "Paths", BaseDirectory: dir}
+
+		options.Paths.Text = pattern
+
+		book := NewBook(options)
+
+		if err := book.Build(context.Background(), io.Discard); err == nil {
+			t.Errorf("%s: want an error, got none", pattern)
+		} else if failures := book.Diagnostics().Errors(); len(failures) != 1 || failures[0].Path != filepath.Join(dir, pattern) {
+			t.Errorf("%s: unexpected errors %v", pattern, failures)
+		}
+	}
+}
+
+func TestTextLanguages(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"a.md":     "# A\n",
+		"b.md":     "# B\n",
+		"sub/c.md": "# C\n",
+		"epub_info.json": `{
+	"title": "Languages",
+	"paths": {"texts": ["a.md", "b.md", "sub/c.md"]},
+	"text_languages": {
+		"./a.md": "fr",
+		"sub/../b.md": "de-CH",
+		"sub/c.md": "not a language"
+	}
+}`,
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	optionsPath := filepath.Join(dir, "epub_info.json")
+
+	options, err := LoadOptions(optionsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	book := NewBook(options)
+
+	if err := book.Build(context.Background(), io.Discard); err == nil {
+		t.Fatal("want an error, got none")
+	}
+
+	failures := book.Diagnostics().Errors()
+
+	if len(failures) != 1 {
+		t.Fatalf("want one error, got %v", failures)
+	}
+
+	if failures[0].Path != optionsPath || failures[0].Line != 7 || !strings.Contains(failures[0].Message, "not a language") {
+		t.Errorf("unexpected error %+v", failures[0])
+	}
+
+	options.TextLanguages["sub/c.md"] = "es"
+
+	r := buildTestBook(t, map[string]string{"a.md": "# A\n", "b.md": "# B\n", "sub/c.md": "# C\n"}, options)
+
+	for i, want := range []string{`xml:lang="fr"`, `xml:lang="de-CH"`, `xml:lang="es"`} {
+		name := "chapter_" + strconv.Itoa(i+1) + ".xhtml"
+
+		if chapter := readTestArchiveFile(t, r, name); !strings.Contains(chapter, want) {
+			t.Errorf("%s does not contain %s", name, want)
+		}
+	}
+}
+
+func TestTextStylesheets(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"text.md":    "# One\n\nText.\n\n# Two\n\nText.\n",
+		"whole.css":  ".whole{color:red}",
+		"second.css": ".second{color:blue}",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	options := Options{
+		Title:                "Stylesheets",
+		ShouldSplitTextFiles: true,
+		TextStylesheets: map[string][]string{
+			"./text.md":       {"whole.css"},
+			"chapter_2.xhtml": {"second.css"},
+		},
+		BaseDirectory: dir,
+	}
+
+	options.Paths.Text = "text.md"
+
+	book := NewBook(options)
+
+	var buffer bytes.Buffer
+
+	if err := book.Build(context.Background(), &buffer); err != nil {
+		t.Fatalf("%v: %v", err, book.Diagnostics())
+	}
+
+	warnings := book.Diagnostics().Warnings()
+
+	if len(warnings) != 1 || !strings.HasSuffix(warnings[0].Message, ": chapter_2.xhtml") {
+		t.Errorf("want a warning about chapter_2.xhtml, got %v", warnings)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wholePath string
+
+	for _, f := range r.File {
+		switch content := readTestArchiveFile(t, r, f.Name); {
+		case strings.Contains(content, ".second"):
+			t.Errorf("%s contains the stylesheet given for a chapter", f.Name)
+		case strings.HasSuffix(f.Name, ".css") && strings.Contains(content, ".whole"):
+			wholePath = f.Name
+		}
+	}
+
+	if wholePath == "" {
+		t.Fatal("the stylesheet given for the text is missing")
+	}
+
+	// both chapters that the text is split into share its stylesheet
+	for _, name := range []string{"chapter_1.xhtml", "chapter_2.xhtml"} {
+		if !strings.Contains(readTestArchiveFile(t, r, name), `href="`+wholePath+`"`) {
+			t.Errorf("%s does not link to %s", name, wholePath)
+		}
+	}
+}
+
+// buildTestBook writes files to a temporary directory and builds a book
+// from them, with the first file, in name order, as its text unless the
+// options name others.
+func buildTestBook(t *testing.T, files map[string]string, options Options) *zip.Reader {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	var names []string
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, name)
+	}
+
+	if options.Paths.Text == "" && len(options.Paths.Texts) == 0 {
+		for _, name := range names {
+			if options.Paths.Text == "" || name < options.Paths.Text {
+				options.Paths.Text = name
+			}
+		}
+	}
+
+	options.BaseDirectory = dir
+
+	book := NewBook(options)
+
+	var buffer bytes.Buffer
+
+	if err := book.Build(context.Background(), &buffer); err != nil {
+		t.Fatalf("%v: %v", err, book.Diagnostics())
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func readTestArchiveFile(t *testing.T, r *zip.Reader, name string) string {
+	t.Helper()
+
+	f, err := r.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
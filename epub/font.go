@@ -0,0 +1,214 @@
+package epub
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Font is a font file embedded in the book and made available to its
+// stylesheets under Family. Fonts with ShouldObfuscate set are obfuscated
+// with the IDPF algorithm, as the licences of many commercial fonts require,
+// and fonts with ShouldSubset set are reduced to the glyphs that the book uses.
+type Font struct {
+	Family          string `json:"family"`
+	Path            string `json:"path"`
+	Weight          int    `json:"weight"`
+	Style           string `json:"style"`
+	ShouldObfuscate bool   `json:"should_obfuscate"`
+	ShouldSubset    bool   `json:"should_subset"`
+}
+
+const (
+	FontStyleNormal  = "normal"
+	FontStyleItalic  = "italic"
+	FontStyleOblique = "oblique"
+)
+
+const (
+	fontObfuscationLength = 1040
+)
+
+var (
+	fontFormats = map[string]string{
+		".ttf":   "truetype",
+		".otf":   "opentype",
+		".woff":  "woff",
+		".woff2": "woff2",
+	}
+	fontMediaTypes = map[string]string{
+		".ttf":   "font/ttf",
+		".otf":   "font/otf",
+		".woff":  "font/woff",
+		".woff2": "font/woff2",
+	}
+	fontLegacyMediaTypes = map[string]string{
+		".ttf":   "application/x-font-truetype",
+		".otf":   "application/vnd.ms-opentype",
+		".woff":  "application/font-woff",
+		".woff2": "font/woff2",
+	}
+)
+
+type epubInfoOutputFont struct {
+	Font
+	datum *epubInfoOutputFileDatum
+}
+
+func epubInfoOutputInitFonts(ei *epubInfo) (err error) {
+	if len(ei.Fonts) == 0 {
+		return
+	}
+
+	var builder strings.Builder
+
+	for _, font := range ei.Fonts {
+		path := ei.resolvePath(font.Path)
+
+		if font.Family == "" {
+			ei.fail(path, 0, "font has no family", nil)
+			continue
+		}
+
+		ext := strings.ToLower(filepath.Ext(font.Path))
+
+		format, ok := fontFormats[ext]
+		if !ok {
+			ei.fail(path, 0, "unsupported font file extension", nil)
+			continue
+		}
+
+		if font.Weight != 0 && (font.Weight < 1 || font.Weight > 1000) {
+			ei.fail(path, 0, "font weight must be between 1 and 1000", nil)
+			continue
+		}
+
+		switch font.Style {
+		case "", FontStyleNormal, FontStyleItalic, FontStyleOblique:
+		default:
+			ei.fail(path, 0, "unsupported font style: "+font.Style, nil)
+			continue
+		}
+
+		datum, datumErr := ei.findFileDatum(path)
+		if datumErr != nil {
+			ei.fail(path, 0, "cannot read font", datumErr)
+			continue
+		}
+
+		if font.ShouldObfuscate {
+			datum.obfuscated = true
+		}
+
+		ei.output.fonts = append(ei.output.fonts, &epubInfoOutputFont{
+			Font:  font,
+			datum: datum,
+		})
+
+		builder.WriteString(`@font-face{`)
+		builder.WriteString(`font-family:"` + cssEscapeString(font.Family) + `";`)
+		builder.WriteString(`src:url("` + datum.path + `") format("` + format + `");`)
+
+		if font.Weight != 0 {
+			builder.WriteString(`font-weight:` + strconv.Itoa(font.Weight) + `;`)
+		}
+
+		if font.Style != "" {
+			builder.WriteString(`font-style:` + font.Style + `;`)
+		}
+
+		builder.WriteString(`}`)
+	}
+
+	if builder.Len() == 0 {
+		return
+	}
+
+	stylesheet, err := ei.addStylesheet([]byte(builder.String()), "")
+	if err != nil {
+		return
+	}
+
+	ei.output.styles = appendStylesheets(ei.output.styles, stylesheet)
+
+	return
+}
+
+// fontMediaType returns the media type of a font file with the given
+// extension, or an empty string if the extension is not that of a font.
+func (ei *epubInfo) fontMediaType(ext string) string {
+	ext = strings.ToLower(ext)
+
+	if ei.isEPUB3() {
+		return fontMediaTypes[ext]
+	}
+
+	return fontLegacyMediaTypes[ext]
+}
+
+// obfuscateFont applies the IDPF font obfuscation algorithm, which XORs the
+// start of the font with the SHA-1 digest of the book's unique identifier.
+// Applying it a second time restores the original font.
+func (ei *epubInfo) obfuscateFont(content []byte) []byte {
+	identifier := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+
+		return r
+	}, ei.output.uniqueIdentifier)
+	key := sha1.Sum([]byte(identifier))
+
+	b := append([]byte(nil), content...)
+
+	for i := 0; i < len(b) && i < fontObfuscationLength; i++ {
+		b[i] ^= key[i%len(key)]
+	}
+
+	return b
+}
+
+func cssEscapeString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\a `).Replace(s)
+}
+
+func generateZipEncryption(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	var builder strings.Builder
+
+	for _, datum := range ei.output.fileData {
+		if !datum.obfuscated {
+			continue
+		}
+
+		builder.WriteString(`<enc:EncryptedData>`)
+		builder.WriteString(`<enc:EncryptionMethod Algorithm="http://www.idpf.org/2008/embedding" />`)
+		builder.WriteString(`<enc:CipherData><enc:CipherReference URI="` + xmlEscape(datum.path) + `" /></enc:CipherData>`)
+		builder.WriteString(`</enc:EncryptedData>`)
+	}
+
+	if builder.Len() == 0 {
+		return
+	}
+
+	w, err := archiveWriter.Create("META-INF/encryption.xml")
+	if err != nil {
+		return
+	}
+
+	var contentBuilder strings.Builder
+
+	contentBuilder.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	contentBuilder.WriteString(`<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">`)
+	contentBuilder.WriteString(builder.String())
+	contentBuilder.WriteString(`</encryption>`)
+
+	if _, err = io.WriteString(w, contentBuilder.String()); err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,629 @@
+package epub
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	cffOperatorCharset     = 15
+	cffOperatorEncoding    = 16
+	cffOperatorCharStrings = 17
+	cffOperatorPrivate     = 18
+	cffOperatorSubrs       = 19
+	cffOperatorFDArray     = 12<<8 | 36
+	cffOperatorFDSelect    = 12<<8 | 37
+)
+
+var (
+	errCFFTruncated = errors.New("font CFF table is truncated")
+)
+
+type cffDictEntry struct {
+	operator int
+	operands [][]byte
+}
+
+type cffDict []*cffDictEntry
+
+func (dict cffDict) entry(operator int) *cffDictEntry {
+	for _, entry := range dict {
+		if entry.operator == operator {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// integers returns the integer operands of the entry for operator,
+// or false if there is no such entry.
+func (dict cffDict) integers(operator int) (values []int, ok bool) {
+	entry := dict.entry(operator)
+	if entry == nil {
+		return
+	}
+
+	for _, operand := range entry.operands {
+		values = append(values, cffDecodeInteger(operand))
+	}
+
+	return values, true
+}
+
+// setOffsets replaces the operands of the entry for operator with integers
+// encoded at a fixed width, so that the size of the DICT does not depend
+// on their values.
+func (dict cffDict) setOffsets(operator int, values ...int) {
+	entry := dict.entry(operator)
+	if entry == nil {
+		return
+	}
+
+	entry.operands = nil
+
+	for _, value := range values {
+		entry.operands = append(entry.operands, []byte{29, byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)})
+	}
+}
+
+func (dict cffDict) bytes() (b []byte) {
+	for _, entry := range dict {
+		for _, operand := range entry.operands {
+			b = append(b, operand...)
+		}
+
+		if entry.operator > 0xff {
+			b = append(b, 12, byte(entry.operator))
+		} else {
+			b = append(b, byte(entry.operator))
+		}
+	}
+
+	return
+}
+
+func parseCFFDict(b []byte) (dict cffDict, err error) {
+	var operands [][]byte
+
+	for p := 0; p < len(b); {
+		b0 := b[p]
+		size := 1
+
+		switch {
+		case b0 == 12:
+			if p+1 >= len(b) {
+				return nil, errCFFTruncated
+			}
+
+			dict = append(dict, &cffDictEntry{operator: 12<<8 | int(b[p+1]), operands: operands})
+			operands = nil
+			p += 2
+
+			continue
+		case b0 <= 21:
+			dict = append(dict, &cffDictEntry{operator: int(b0), operands: operands})
+			operands = nil
+			p++
+
+			continue
+		case b0 == 28:
+			size = 3
+		case b0 == 29:
+			size = 5
+		case b0 == 30:
+			for size = 1; p+size < len(b); size++ {
+				if b[p+size]&0x0f == 0x0f || b[p+size]>>4 == 0x0f {
+					size++
+					break
+				}
+			}
+		case b0 >= 32 && b0 <= 246:
+		case b0 >= 247 && b0 <= 254:
+			size = 2
+		default:
+			return nil, errors.New("font CFF table has an invalid DICT")
+		}
+
+		if p+size > len(b) {
+			return nil, errCFFTruncated
+		}
+
+		operands = append(operands, b[p:p+size])
+		p += size
+	}
+
+	return
+}
+
+func cffDecodeInteger(operand []byte) int {
+	b0 := operand[0]
+
+	switch {
+	case b0 == 28:
+		return int(int16(binary.BigEndian.Uint16(operand[1:])))
+	case b0 == 29:
+		return int(int32(binary.BigEndian.Uint32(operand[1:])))
+	case b0 >= 32 && b0 <= 246:
+		return int(b0) - 139
+	case b0 >= 247 && b0 <= 250:
+		return (int(b0)-247)*256 + int(operand[1]) + 108
+	case b0 >= 251 && b0 <= 254:
+		return -(int(b0)-251)*256 - int(operand[1]) - 108
+	}
+
+	return 0
+}
+
+// parseCFFIndex returns the items of the INDEX found at offset and the
+// offset of the first byte after it.
+func parseCFFIndex(b []byte, offset int) (items [][]byte, end int, err error) {
+	if offset < 0 || offset+2 > len(b) {
+		return nil, 0, errCFFTruncated
+	}
+
+	count := int(binary.BigEndian.Uint16(b[offset:]))
+	if count == 0 {
+		return nil, offset + 2, nil
+	}
+
+	if offset+3 > len(b) {
+		return nil, 0, errCFFTruncated
+	}
+
+	offSize := int(b[offset+2])
+	if offSize < 1 || offSize > 4 {
+		return nil, 0, errors.New("font CFF table has an invalid INDEX")
+	}
+
+	offsetsStart := offset + 3
+	dataStart := offsetsStart + (count+1)*offSize - 1
+
+	if dataStart > len(b) {
+		return nil, 0, errCFFTruncated
+	}
+
+	readOffset := func(i int) (value int) {
+		for _, c := range b[offsetsStart+i*offSize : offsetsStart+(i+1)*offSize] {
+			value = value<<8 | int(c)
+		}
+
+		return
+	}
+
+	for i := 0; i < count; i++ {
+		start, end := dataStart+readOffset(i), dataStart+readOffset(i+1)
+
+		if start < dataStart || end < start || end > len(b) {
+			return nil, 0, errCFFTruncated
+		}
+
+		items = append(items, b[start:end])
+	}
+
+	return items, dataStart + readOffset(count), nil
+}
+
+func cffIndex(items [][]byte) (b []byte) {
+	if len(items) == 0 {
+		return []byte{0, 0}
+	}
+
+	dataSize := 0
+
+	for _, item := range items {
+		dataSize += len(item)
+	}
+
+	offSize := 1
+
+	for dataSize+1 >= 1<<(8*offSize) {
+		offSize++
+	}
+
+	b = append(b, byte(len(items)>>8), byte(len(items)), byte(offSize))
+
+	writeOffset := func(value int) {
+		for i := offSize - 1; i >= 0; i-- {
+			b = append(b, byte(value>>(8*i)))
+		}
+	}
+
+	offset := 1
+
+	writeOffset(offset)
+
+	for _, item := range items {
+		offset += len(item)
+		writeOffset(offset)
+	}
+
+	for _, item := range items {
+		b = append(b, item...)
+	}
+
+	return
+}
+
+// cffPrivate is a Private DICT together with the local subroutines
+// that it refers to, which are written immediately after it.
+type cffPrivate struct {
+	dict  cffDict
+	subrs []byte
+}
+
+func parseCFFPrivate(b []byte, values []int) (private *cffPrivate, err error) {
+	if len(values) != 2 {
+		return nil, errors.New("font CFF table has an invalid Private DICT")
+	}
+
+	size, offset := values[0], values[1]
+
+	if offset < 0 || size < 0 || offset+size > len(b) {
+		return nil, errCFFTruncated
+	}
+
+	private = &cffPrivate{}
+
+	if private.dict, err = parseCFFDict(b[offset : offset+size]); err != nil {
+		return
+	}
+
+	if subrs, ok := private.dict.integers(cffOperatorSubrs); ok && len(subrs) == 1 {
+		var end int
+
+		if _, end, err = parseCFFIndex(b, offset+subrs[0]); err != nil {
+			return
+		}
+
+		private.subrs = b[offset+subrs[0] : end]
+
+		private.dict.setOffsets(cffOperatorSubrs, 0)
+	}
+
+	return
+}
+
+func (private *cffPrivate) bytes() []byte {
+	dict := private.dict.bytes()
+
+	if private.subrs != nil {
+		private.dict.setOffsets(cffOperatorSubrs, len(dict))
+		dict = private.dict.bytes()
+	}
+
+	return append(dict, private.subrs...)
+}
+
+func (private *cffPrivate) dictSize() int {
+	return len(private.dict.bytes())
+}
+
+// subsetCFF replaces the charstring of every glyph that is not needed with
+// one that draws nothing, and rebuilds the CFF table around the new
+// CharStrings INDEX, since every structure after it may move.
+func subsetCFF(f *sfntFont, numGlyphs int, glyphs map[uint16]bool) (err error) {
+	table := f.table("CFF ")
+	b := table.data
+
+	if len(b) < 4 {
+		return errCFFTruncated
+	}
+
+	headerSize := int(b[2])
+
+	names, end, err := parseCFFIndex(b, headerSize)
+	if err != nil {
+		return
+	}
+
+	nameIndexEnd := end
+
+	topDicts, end, err := parseCFFIndex(b, end)
+	if err != nil {
+		return
+	}
+
+	if len(topDicts) != 1 || len(names) != 1 {
+		return errors.New("font CFF table must contain exactly one font")
+	}
+
+	topDict, err := parseCFFDict(topDicts[0])
+	if err != nil {
+		return
+	}
+
+	stringsStart := end
+
+	if _, end, err = parseCFFIndex(b, end); err != nil {
+		return
+	}
+
+	if _, end, err = parseCFFIndex(b, end); err != nil {
+		return
+	}
+
+	// the String and Global Subr INDEXes are copied unchanged
+	sharedData := b[stringsStart:end]
+
+	charStringsOffset, ok := topDict.integers(cffOperatorCharStrings)
+	if !ok || len(charStringsOffset) != 1 {
+		return errors.New("font CFF table has no CharStrings")
+	}
+
+	charStrings, _, err := parseCFFIndex(b, charStringsOffset[0])
+	if err != nil {
+		return
+	}
+
+	if len(charStrings) != numGlyphs {
+		return errors.New("font CFF table has the wrong number of CharStrings")
+	}
+
+	for i := range charStrings {
+		if !glyphs[uint16(i)] {
+			charStrings[i] = []byte{14} // endchar
+		}
+	}
+
+	var charset, encoding, fdSelect []byte
+
+	if values, ok := topDict.integers(cffOperatorCharset); ok && len(values) == 1 && values[0] > 2 {
+		if charset, err = cffCharset(b, values[0], numGlyphs); err != nil {
+			return
+		}
+	}
+
+	if values, ok := topDict.integers(cffOperatorEncoding); ok && len(values) == 1 && values[0] > 1 {
+		if encoding, err = cffEncoding(b, values[0]); err != nil {
+			return
+		}
+	}
+
+	if values, ok := topDict.integers(cffOperatorFDSelect); ok && len(values) == 1 {
+		if fdSelect, err = cffFDSelect(b, values[0], numGlyphs); err != nil {
+			return
+		}
+	}
+
+	var privates []*cffPrivate
+	var fontDicts []cffDict
+
+	if values, ok := topDict.integers(cffOperatorPrivate); ok {
+		var private *cffPrivate
+
+		if private, err = parseCFFPrivate(b, values); err != nil {
+			return
+		}
+
+		privates = append(privates, private)
+	}
+
+	if values, ok := topDict.integers(cffOperatorFDArray); ok && len(values) == 1 {
+		var items [][]byte
+
+		if items, _, err = parseCFFIndex(b, values[0]); err != nil {
+			return
+		}
+
+		for _, item := range items {
+			var fontDict cffDict
+
+			if fontDict, err = parseCFFDict(item); err != nil {
+				return
+			}
+
+			values, ok := fontDict.integers(cffOperatorPrivate)
+			if !ok {
+				return errors.New("font CFF table has a Font DICT without a Private DICT")
+			}
+
+			var private *cffPrivate
+
+			if private, err = parseCFFPrivate(b, values); err != nil {
+				return
+			}
+
+			fontDict.setOffsets(cffOperatorPrivate, 0, 0)
+
+			fontDicts = append(fontDicts, fontDict)
+			privates = append(privates, private)
+		}
+	}
+
+	if charset != nil {
+		topDict.setOffsets(cffOperatorCharset, 0)
+	}
+
+	if encoding != nil {
+		topDict.setOffsets(cffOperatorEncoding, 0)
+	}
+
+	topDict.setOffsets(cffOperatorFDSelect, 0)
+	topDict.setOffsets(cffOperatorCharStrings, 0)
+	topDict.setOffsets(cffOperatorFDArray, 0)
+	topDict.setOffsets(cffOperatorPrivate, 0, 0)
+
+	// every offset is now written at a fixed width, so the sizes of the
+	// structures are known before the offsets themselves
+	offset := nameIndexEnd + len(cffIndex([][]byte{topDict.bytes()})) + len(sharedData)
+
+	charsetOffset := offset
+	offset += len(charset)
+
+	encodingOffset := offset
+	offset += len(encoding)
+
+	fdSelectOffset := offset
+	offset += len(fdSelect)
+
+	charStringsIndex := cffIndex(charStrings)
+	charStringsIndexOffset := offset
+	offset += len(charStringsIndex)
+
+	fontDictItems := make([][]byte, len(fontDicts))
+
+	for i, fontDict := range fontDicts {
+		fontDictItems[i] = fontDict.bytes()
+	}
+
+	fdArrayOffset := offset
+
+	if len(fontDicts) > 0 {
+		offset += len(cffIndex(fontDictItems))
+	}
+
+	privateOffsets := make([]int, len(privates))
+	privateBytes := make([][]byte, len(privates))
+
+	for i, private := range privates {
+		privateOffsets[i] = offset
+		privateBytes[i] = private.bytes()
+		offset += len(privateBytes[i])
+	}
+
+	if charset != nil {
+		topDict.setOffsets(cffOperatorCharset, charsetOffset)
+	}
+
+	if encoding != nil {
+		topDict.setOffsets(cffOperatorEncoding, encodingOffset)
+	}
+
+	if fdSelect != nil {
+		topDict.setOffsets(cffOperatorFDSelect, fdSelectOffset)
+	}
+
+	topDict.setOffsets(cffOperatorCharStrings, charStringsIndexOffset)
+	topDict.setOffsets(cffOperatorFDArray, fdArrayOffset)
+
+	privateIndex := 0
+
+	if topDict.entry(cffOperatorPrivate) != nil {
+		topDict.setOffsets(cffOperatorPrivate, privates[0].dictSize(), privateOffsets[0])
+		privateIndex++
+	}
+
+	for i, fontDict := range fontDicts {
+		fontDict.setOffsets(cffOperatorPrivate, privates[privateIndex].dictSize(), privateOffsets[privateIndex])
+		fontDictItems[i] = fontDict.bytes()
+		privateIndex++
+	}
+
+	cff := append([]byte(nil), b[:nameIndexEnd]...)
+	cff = append(cff, cffIndex([][]byte{topDict.bytes()})...)
+	cff = append(cff, sharedData...)
+	cff = append(cff, charset...)
+	cff = append(cff, encoding...)
+	cff = append(cff, fdSelect...)
+	cff = append(cff, charStringsIndex...)
+
+	if len(fontDicts) > 0 {
+		cff = append(cff, cffIndex(fontDictItems)...)
+	}
+
+	for _, private := range privateBytes {
+		cff = append(cff, private...)
+	}
+
+	table.data = cff
+
+	return
+}
+
+func cffCharset(b []byte, offset, numGlyphs int) (charset []byte, err error) {
+	if offset >= len(b) {
+		return nil, errCFFTruncated
+	}
+
+	end := offset + 1
+
+	switch b[offset] {
+	case 0:
+		end += (numGlyphs - 1) * 2
+	case 1, 2:
+		rangeSize := 3
+
+		if b[offset] == 2 {
+			rangeSize = 4
+		}
+
+		for covered := 1; covered < numGlyphs; end += rangeSize {
+			if end+rangeSize > len(b) {
+				return nil, errCFFTruncated
+			}
+
+			if rangeSize == 3 {
+				covered += int(b[end+2]) + 1
+			} else {
+				covered += int(binary.BigEndian.Uint16(b[end+2:])) + 1
+			}
+		}
+	default:
+		return nil, errors.New("font CFF table has an unsupported charset")
+	}
+
+	if end > len(b) {
+		return nil, errCFFTruncated
+	}
+
+	return b[offset:end], nil
+}
+
+func cffEncoding(b []byte, offset int) (encoding []byte, err error) {
+	if offset+2 > len(b) {
+		return nil, errCFFTruncated
+	}
+
+	end := offset + 2
+
+	switch b[offset] & 0x7f {
+	case 0:
+		end += int(b[offset+1])
+	case 1:
+		end += int(b[offset+1]) * 2
+	default:
+		return nil, errors.New("font CFF table has an unsupported encoding")
+	}
+
+	if b[offset]&0x80 != 0 {
+		if end >= len(b) {
+			return nil, errCFFTruncated
+		}
+
+		end += 1 + int(b[end])*3
+	}
+
+	if end > len(b) {
+		return nil, errCFFTruncated
+	}
+
+	return b[offset:end], nil
+}
+
+func cffFDSelect(b []byte, offset, numGlyphs int) (fdSelect []byte, err error) {
+	if offset >= len(b) {
+		return nil, errCFFTruncated
+	}
+
+	var end int
+
+	switch b[offset] {
+	case 0:
+		end = offset + 1 + numGlyphs
+	case 3:
+		if offset+3 > len(b) {
+			return nil, errCFFTruncated
+		}
+
+		end = offset + 3 + int(binary.BigEndian.Uint16(b[offset+1:]))*3 + 2
+	default:
+		return nil, errors.New("font CFF table has an unsupported FDSelect")
+	}
+
+	if end > len(b) {
+		return nil, errCFFTruncated
+	}
+
+	return b[offset:end], nil
+}
@@ -0,0 +1,792 @@
+package epub
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+type sfntTable struct {
+	tag  string
+	data []byte
+}
+
+type sfntFont struct {
+	version uint32
+	tables  []*sfntTable
+}
+
+func (f *sfntFont) table(tag string) *sfntTable {
+	for _, table := range f.tables {
+		if table.tag == tag {
+			return table
+		}
+	}
+
+	return nil
+}
+
+func (f *sfntFont) removeTable(tag string) {
+	for i, table := range f.tables {
+		if table.tag == tag {
+			f.tables = append(f.tables[:i], f.tables[i+1:]...)
+			return
+		}
+	}
+}
+
+func parseSFNT(b []byte) (f *sfntFont, err error) {
+	if len(b) < 12 {
+		return nil, errors.New("font file is truncated")
+	}
+
+	f = &sfntFont{
+		version: binary.BigEndian.Uint32(b),
+	}
+
+	switch f.version {
+	case 0x00010000, 0x4f54544f, 0x74727565: // TrueType, "OTTO" and "true"
+	case 0x74746366: // "ttcf"
+		return nil, errors.New("font collections are not supported")
+	default:
+		return nil, errors.New("unrecognized font format")
+	}
+
+	numTables := int(binary.BigEndian.Uint16(b[4:]))
+
+	if len(b) < 12+numTables*16 {
+		return nil, errors.New("font table directory is truncated")
+	}
+
+	for i := 0; i < numTables; i++ {
+		record := b[12+i*16:]
+		offset := int(binary.BigEndian.Uint32(record[8:]))
+		length := int(binary.BigEndian.Uint32(record[12:]))
+
+		if offset < 0 || length < 0 || offset+length > len(b) || offset+length < offset {
+			return nil, errors.New("font table is out of bounds: " + string(record[:4]))
+		}
+
+		f.tables = append(f.tables, &sfntTable{
+			tag:  string(record[:4]),
+			data: b[offset : offset+length],
+		})
+	}
+
+	return
+}
+
+// bytes serializes the font, recalculating the table checksums
+// and the checksum adjustment in the head table.
+func (f *sfntFont) bytes() []byte {
+	sort.Slice(f.tables, func(i, j int) bool {
+		return f.tables[i].tag < f.tables[j].tag
+	})
+
+	numTables := len(f.tables)
+	entrySelector := 0
+
+	for 1<<(entrySelector+1) <= numTables {
+		entrySelector++
+	}
+
+	searchRange := (1 << entrySelector) * 16
+
+	var buffer bytes.Buffer
+
+	header := make([]byte, 12+numTables*16)
+
+	binary.BigEndian.PutUint32(header, f.version)
+	binary.BigEndian.PutUint16(header[4:], uint16(numTables))
+	binary.BigEndian.PutUint16(header[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(header[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(header[10:], uint16(numTables*16-searchRange))
+
+	buffer.Write(header)
+
+	var headOffset int
+
+	for i, table := range f.tables {
+		data := table.data
+
+		if table.tag == "head" && len(data) >= 12 {
+			data = append([]byte(nil), data...)
+			binary.BigEndian.PutUint32(data[8:], 0)
+			headOffset = buffer.Len()
+		}
+
+		record := header[12+i*16:]
+
+		copy(record, table.tag)
+		binary.BigEndian.PutUint32(record[4:], sfntChecksum(data))
+		binary.BigEndian.PutUint32(record[8:], uint32(buffer.Len()))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(data)))
+
+		buffer.Write(data)
+
+		for buffer.Len()%4 != 0 {
+			buffer.WriteByte(0)
+		}
+	}
+
+	b := buffer.Bytes()
+
+	copy(b, header)
+
+	if headOffset > 0 {
+		binary.BigEndian.PutUint32(b[headOffset+8:], 0xb1b0afba-sfntChecksum(b))
+	}
+
+	return b
+}
+
+func sfntChecksum(b []byte) (sum uint32) {
+	for i := 0; i < len(b); i += 4 {
+		var word [4]byte
+
+		copy(word[:], b[i:])
+
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+
+	return
+}
+
+// subsetFont removes the outlines of every glyph that is not needed to
+// render the given runes, along with the character mappings of every other
+// rune. Glyph identifiers are left unchanged, so that tables referring to
+// glyphs, such as those for metrics, kerning and substitution, remain valid.
+func subsetFont(b []byte, runes map[rune]bool) (subset []byte, err error) {
+	var isWOFF bool
+
+	if len(b) >= 4 {
+		switch string(b[:4]) {
+		case "wOFF":
+			if b, err = decodeWOFF(b); err != nil {
+				return
+			}
+
+			isWOFF = true
+		case "wOF2":
+			return nil, errors.New("WOFF2 fonts are not supported")
+		}
+	}
+
+	f, err := parseSFNT(b)
+	if err != nil {
+		return
+	}
+
+	maxp := f.table("maxp")
+	if maxp == nil || len(maxp.data) < 6 {
+		return nil, errors.New("font has no maxp table")
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(maxp.data[4:]))
+
+	parsed, err := sfnt.Parse(b)
+	if err != nil {
+		return
+	}
+
+	var buffer sfnt.Buffer
+
+	glyphs := map[uint16]bool{0: true}
+	mapping := make(map[rune]uint16)
+
+	for r := range runes {
+		index, err := parsed.GlyphIndex(&buffer, r)
+		if err != nil || index == 0 || int(index) >= numGlyphs {
+			continue
+		}
+
+		glyphs[uint16(index)] = true
+		mapping[r] = uint16(index)
+	}
+
+	if gsub := f.table("GSUB"); gsub != nil {
+		closeGSUBGlyphs(gsub.data, glyphs)
+	}
+
+	switch {
+	case f.table("glyf") != nil:
+		err = subsetGlyf(f, numGlyphs, glyphs)
+	case f.table("CFF ") != nil:
+		err = subsetCFF(f, numGlyphs, glyphs)
+	default:
+		err = errors.New("font has no supported glyph outlines")
+	}
+	if err != nil {
+		return
+	}
+
+	// symbol fonts are left with their original character mappings,
+	// which are not in terms of Unicode
+	if cmap := f.table("cmap"); cmap != nil && cmapHasUnicode(cmap.data) {
+		if b, ok := buildCmap(mapping); ok {
+			cmap.data = b
+		}
+	}
+
+	// any digital signature is invalidated by subsetting
+	f.removeTable("DSIG")
+
+	subset = f.bytes()
+
+	if isWOFF {
+		subset, err = encodeWOFF(f)
+	}
+
+	return
+}
+
+func subsetGlyf(f *sfntFont, numGlyphs int, glyphs map[uint16]bool) (err error) {
+	head, glyf, loca := f.table("head"), f.table("glyf"), f.table("loca")
+	if head == nil || len(head.data) < 54 || loca == nil {
+		return errors.New("font has no head or loca table")
+	}
+
+	isLong := binary.BigEndian.Uint16(head.data[50:]) == 1
+	offsets := make([]int, numGlyphs+1)
+
+	for i := range offsets {
+		if isLong {
+			if len(loca.data) < i*4+4 {
+				return errors.New("font loca table is truncated")
+			}
+
+			offsets[i] = int(binary.BigEndian.Uint32(loca.data[i*4:]))
+		} else {
+			if len(loca.data) < i*2+2 {
+				return errors.New("font loca table is truncated")
+			}
+
+			offsets[i] = int(binary.BigEndian.Uint16(loca.data[i*2:])) * 2
+		}
+	}
+
+	glyph := func(index uint16) []byte {
+		if int(index) >= numGlyphs {
+			return nil
+		}
+
+		start, end := offsets[index], offsets[int(index)+1]
+
+		if start >= end || end > len(glyf.data) {
+			return nil
+		}
+
+		return glyf.data[start:end]
+	}
+
+	pending := make([]uint16, 0, len(glyphs))
+
+	for index := range glyphs {
+		pending = append(pending, index)
+	}
+
+	for len(pending) > 0 {
+		index := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		for _, component := range glyfComponents(glyph(index)) {
+			if int(component) < numGlyphs && !glyphs[component] {
+				glyphs[component] = true
+				pending = append(pending, component)
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+
+	newOffsets := make([]int, numGlyphs+1)
+
+	for i := 0; i < numGlyphs; i++ {
+		newOffsets[i] = buffer.Len()
+
+		if glyphs[uint16(i)] {
+			buffer.Write(glyph(uint16(i)))
+
+			for buffer.Len()%4 != 0 {
+				buffer.WriteByte(0)
+			}
+		}
+	}
+
+	newOffsets[numGlyphs] = buffer.Len()
+
+	isLong = buffer.Len() > 0x1fffe
+
+	var newLoca []byte
+
+	if isLong {
+		newLoca = make([]byte, (numGlyphs+1)*4)
+
+		for i, offset := range newOffsets {
+			binary.BigEndian.PutUint32(newLoca[i*4:], uint32(offset))
+		}
+	} else {
+		newLoca = make([]byte, (numGlyphs+1)*2)
+
+		for i, offset := range newOffsets {
+			binary.BigEndian.PutUint16(newLoca[i*2:], uint16(offset/2))
+		}
+	}
+
+	head.data = append([]byte(nil), head.data...)
+
+	if isLong {
+		binary.BigEndian.PutUint16(head.data[50:], 1)
+	} else {
+		binary.BigEndian.PutUint16(head.data[50:], 0)
+	}
+
+	glyf.data = buffer.Bytes()
+	loca.data = newLoca
+
+	return
+}
+
+// glyfComponents returns the glyphs from which a composite glyph is built.
+func glyfComponents(glyph []byte) (components []uint16) {
+	if len(glyph) < 10 || int16(binary.BigEndian.Uint16(glyph)) >= 0 {
+		return
+	}
+
+	for p := 10; p+4 <= len(glyph); {
+		flags := binary.BigEndian.Uint16(glyph[p:])
+
+		components = append(components, binary.BigEndian.Uint16(glyph[p+2:]))
+
+		p += 4
+
+		if flags&0x0001 != 0 { // ARG_1_AND_2_ARE_WORDS
+			p += 4
+		} else {
+			p += 2
+		}
+
+		switch {
+		case flags&0x0008 != 0: // WE_HAVE_A_SCALE
+			p += 2
+		case flags&0x0040 != 0: // WE_HAVE_AN_X_AND_Y_SCALE
+			p += 4
+		case flags&0x0080 != 0: // WE_HAVE_A_TWO_BY_TWO
+			p += 8
+		}
+
+		if flags&0x0020 == 0 { // MORE_COMPONENTS
+			break
+		}
+	}
+
+	return
+}
+
+// fontData reads big-endian values from font tables, returning zero
+// for values beyond the end of the table instead of failing.
+type fontData []byte
+
+func (d fontData) uint16(offset int) int {
+	if offset < 0 || offset+2 > len(d) {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint16(d[offset:]))
+}
+
+func (d fontData) uint32(offset int) int {
+	if offset < 0 || offset+4 > len(d) {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint32(d[offset:]))
+}
+
+func (d fontData) slice(offset int) fontData {
+	if offset < 0 || offset > len(d) {
+		return nil
+	}
+
+	return d[offset:]
+}
+
+func (d fontData) coverage(offset int) (glyphs []uint16) {
+	coverage := d.slice(offset)
+
+	switch coverage.uint16(0) {
+	case 1:
+		for i := 0; i < coverage.uint16(2); i++ {
+			glyphs = append(glyphs, uint16(coverage.uint16(4+i*2)))
+		}
+	case 2:
+		for i := 0; i < coverage.uint16(2); i++ {
+			start, end := coverage.uint16(4+i*6), coverage.uint16(6+i*6)
+
+			for glyph := start; glyph <= end; glyph++ {
+				glyphs = append(glyphs, uint16(glyph))
+			}
+		}
+	}
+
+	return
+}
+
+// closeGSUBGlyphs adds every glyph that a substitution could produce from
+// the given glyphs. Contextual lookups are not evaluated, since the lookups
+// that they apply are themselves included, so the result may be a superset.
+func closeGSUBGlyphs(gsub []byte, glyphs map[uint16]bool) {
+	d := fontData(gsub)
+	lookupList := d.slice(d.uint16(8))
+
+	for changed := true; changed; {
+		changed = false
+
+		add := func(glyph int) {
+			if !glyphs[uint16(glyph)] {
+				glyphs[uint16(glyph)] = true
+				changed = true
+			}
+		}
+
+		for i := 0; i < lookupList.uint16(0); i++ {
+			lookup := lookupList.slice(lookupList.uint16(2 + i*2))
+			lookupType := lookup.uint16(0)
+
+			for j := 0; j < lookup.uint16(4); j++ {
+				closeGSUBSubtable(lookupType, lookup.slice(lookup.uint16(6+j*2)), glyphs, add)
+			}
+		}
+	}
+}
+
+func closeGSUBSubtable(lookupType int, subtable fontData, glyphs map[uint16]bool, add func(int)) {
+	coverage := subtable.coverage(subtable.uint16(2))
+
+	switch lookupType {
+	case 1: // single
+		for i, glyph := range coverage {
+			if !glyphs[glyph] {
+				continue
+			}
+
+			if subtable.uint16(0) == 1 {
+				add((int(glyph) + subtable.uint16(4)) & 0xffff)
+			} else {
+				add(subtable.uint16(6 + i*2))
+			}
+		}
+	case 2, 3: // multiple and alternate
+		for i, glyph := range coverage {
+			if !glyphs[glyph] {
+				continue
+			}
+
+			sequence := subtable.slice(subtable.uint16(6 + i*2))
+
+			for k := 0; k < sequence.uint16(0); k++ {
+				add(sequence.uint16(2 + k*2))
+			}
+		}
+	case 4: // ligature
+		for i, glyph := range coverage {
+			if !glyphs[glyph] {
+				continue
+			}
+
+			ligatureSet := subtable.slice(subtable.uint16(6 + i*2))
+
+			for k := 0; k < ligatureSet.uint16(0); k++ {
+				ligature := ligatureSet.slice(ligatureSet.uint16(2 + k*2))
+				hasComponents := true
+
+				for c := 0; c < ligature.uint16(2)-1; c++ {
+					if !glyphs[uint16(ligature.uint16(4+c*2))] {
+						hasComponents = false
+						break
+					}
+				}
+
+				if hasComponents {
+					add(ligature.uint16(0))
+				}
+			}
+		}
+	case 7: // extension
+		closeGSUBSubtable(subtable.uint16(2), subtable.slice(subtable.uint32(4)), glyphs, add)
+	case 8: // reverse chaining single
+		backtrackCount := subtable.uint16(4)
+		lookaheadCount := subtable.uint16(6 + backtrackCount*2)
+		substitutes := 10 + backtrackCount*2 + lookaheadCount*2
+
+		for i, glyph := range coverage {
+			if glyphs[glyph] {
+				add(subtable.uint16(substitutes + i*2))
+			}
+		}
+	}
+}
+
+func cmapHasUnicode(cmap []byte) bool {
+	d := fontData(cmap)
+
+	for i := 0; i < d.uint16(2); i++ {
+		platformID, encodingID := d.uint16(4+i*8), d.uint16(6+i*8)
+
+		if platformID == 0 || platformID == 3 && (encodingID == 1 || encodingID == 10) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildCmap returns a cmap table holding the given mapping, or false if
+// the mapping is too large to be held in a format 4 subtable.
+func buildCmap(mapping map[rune]uint16) (cmap []byte, ok bool) {
+	runes := make([]rune, 0, len(mapping))
+
+	for r := range mapping {
+		runes = append(runes, r)
+	}
+
+	sort.Slice(runes, func(i, j int) bool {
+		return runes[i] < runes[j]
+	})
+
+	type group struct {
+		start, end rune
+		glyph      uint16
+	}
+
+	var groups []group
+
+	for _, r := range runes {
+		if n := len(groups); n > 0 && groups[n-1].end == r-1 && int(groups[n-1].glyph)+int(r-groups[n-1].start) == int(mapping[r]) {
+			groups[n-1].end = r
+			continue
+		}
+
+		groups = append(groups, group{start: r, end: r, glyph: mapping[r]})
+	}
+
+	var bmpGroups []group
+	var hasSupplementary bool
+
+	for _, g := range groups {
+		if g.start > 0xffff {
+			hasSupplementary = true
+			break
+		}
+
+		if g.end > 0xffff {
+			hasSupplementary = true
+			g.end = 0xffff
+		}
+
+		bmpGroups = append(bmpGroups, g)
+	}
+
+	bmpGroups = append(bmpGroups, group{start: 0xffff, end: 0xffff, glyph: 0})
+
+	segCount := len(bmpGroups)
+	format4Length := 16 + segCount*8
+
+	if format4Length > 0xffff {
+		return nil, false
+	}
+
+	entrySelector := 0
+
+	for 1<<(entrySelector+1) <= segCount {
+		entrySelector++
+	}
+
+	searchRange := (1 << entrySelector) * 2
+
+	format4 := make([]byte, format4Length)
+
+	binary.BigEndian.PutUint16(format4, 4)
+	binary.BigEndian.PutUint16(format4[2:], uint16(format4Length))
+	binary.BigEndian.PutUint16(format4[6:], uint16(segCount*2))
+	binary.BigEndian.PutUint16(format4[8:], uint16(searchRange))
+	binary.BigEndian.PutUint16(format4[10:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(format4[12:], uint16(segCount*2-searchRange))
+
+	for i, g := range bmpGroups {
+		delta := uint16(int(g.glyph) - int(g.start))
+
+		if g.start == 0xffff {
+			delta = 1
+		}
+
+		binary.BigEndian.PutUint16(format4[14+i*2:], uint16(g.end))
+		binary.BigEndian.PutUint16(format4[16+segCount*2+i*2:], uint16(g.start))
+		binary.BigEndian.PutUint16(format4[16+segCount*4+i*2:], delta)
+	}
+
+	subtables := [][]byte{format4}
+
+	if hasSupplementary {
+		format12 := make([]byte, 16+len(groups)*12)
+
+		binary.BigEndian.PutUint16(format12, 12)
+		binary.BigEndian.PutUint32(format12[4:], uint32(len(format12)))
+		binary.BigEndian.PutUint32(format12[12:], uint32(len(groups)))
+
+		for i, g := range groups {
+			binary.BigEndian.PutUint32(format12[16+i*12:], uint32(g.start))
+			binary.BigEndian.PutUint32(format12[20+i*12:], uint32(g.end))
+			binary.BigEndian.PutUint32(format12[24+i*12:], uint32(g.glyph))
+		}
+
+		subtables = append(subtables, format12)
+	}
+
+	header := make([]byte, 4+len(subtables)*8)
+	offset := len(header)
+
+	binary.BigEndian.PutUint16(header[2:], uint16(len(subtables)))
+
+	for i, encodingID := range []uint16{1, 10}[:len(subtables)] {
+		binary.BigEndian.PutUint16(header[4+i*8:], 3) // Windows platform
+		binary.BigEndian.PutUint16(header[6+i*8:], encodingID)
+		binary.BigEndian.PutUint32(header[8+i*8:], uint32(offset))
+
+		offset += len(subtables[i])
+	}
+
+	cmap = header
+
+	for _, subtable := range subtables {
+		cmap = append(cmap, subtable...)
+	}
+
+	return cmap, true
+}
+
+func decodeWOFF(b []byte) (sfntBytes []byte, err error) {
+	if len(b) < 44 {
+		return nil, errors.New("WOFF file is truncated")
+	}
+
+	numTables := int(binary.BigEndian.Uint16(b[12:]))
+
+	if len(b) < 44+numTables*20 {
+		return nil, errors.New("WOFF table directory is truncated")
+	}
+
+	f := &sfntFont{
+		version: binary.BigEndian.Uint32(b[4:]),
+	}
+
+	for i := 0; i < numTables; i++ {
+		entry := b[44+i*20:]
+		offset := int(binary.BigEndian.Uint32(entry[4:]))
+		compLength := int(binary.BigEndian.Uint32(entry[8:]))
+		origLength := int(binary.BigEndian.Uint32(entry[12:]))
+
+		if offset < 0 || compLength < 0 || offset+compLength > len(b) || offset+compLength < offset {
+			return nil, errors.New("WOFF table is out of bounds: " + string(entry[:4]))
+		}
+
+		data := b[offset : offset+compLength]
+
+		if compLength < origLength {
+			var r io.ReadCloser
+
+			if r, err = zlib.NewReader(bytes.NewReader(data)); err != nil {
+				return
+			}
+
+			data, err = io.ReadAll(io.LimitReader(r, int64(origLength)))
+
+			r.Close()
+
+			if err != nil {
+				return
+			}
+		}
+
+		if len(data) != origLength {
+			return nil, errors.New("WOFF table has the wrong length: " + string(entry[:4]))
+		}
+
+		f.tables = append(f.tables, &sfntTable{
+			tag:  string(entry[:4]),
+			data: data,
+		})
+	}
+
+	return f.bytes(), nil
+}
+
+// encodeWOFF serializes the font as WOFF, without any metadata
+// or private data block.
+func encodeWOFF(f *sfntFont) (b []byte, err error) {
+	sfntBytes := f.bytes()
+
+	parsed, err := parseSFNT(sfntBytes)
+	if err != nil {
+		return
+	}
+
+	header := make([]byte, 44+len(parsed.tables)*20)
+
+	var data bytes.Buffer
+
+	for i, table := range parsed.tables {
+		var compressed bytes.Buffer
+
+		w := zlib.NewWriter(&compressed)
+
+		if _, err = w.Write(table.data); err != nil {
+			return
+		}
+
+		if err = w.Close(); err != nil {
+			return
+		}
+
+		stored := table.data
+
+		if compressed.Len() < len(table.data) {
+			stored = compressed.Bytes()
+		}
+
+		checksumData := table.data
+
+		if table.tag == "head" && len(checksumData) >= 12 {
+			checksumData = append([]byte(nil), checksumData...)
+			binary.BigEndian.PutUint32(checksumData[8:], 0)
+		}
+
+		entry := header[44+i*20:]
+
+		copy(entry, table.tag)
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(header)+data.Len()))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(stored)))
+		binary.BigEndian.PutUint32(entry[12:], uint32(len(table.data)))
+		binary.BigEndian.PutUint32(entry[16:], sfntChecksum(checksumData))
+
+		data.Write(stored)
+
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	copy(header, "wOFF")
+	binary.BigEndian.PutUint32(header[4:], parsed.version)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(header)+data.Len()))
+	binary.BigEndian.PutUint16(header[12:], uint16(len(parsed.tables)))
+	binary.BigEndian.PutUint32(header[16:], uint32(len(sfntBytes)))
+	binary.BigEndian.PutUint16(header[20:], 1)
+
+	return append(header, data.Bytes()...), nil
+}
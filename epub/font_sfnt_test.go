@@ -0,0 +1,115 @@
+package epub
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestSubsetFont(t *testing.T) {
+	// CFFTest.otf is the test font of golang.org/x/image/font/sfnt,
+	// which maps '0', '1' and 'Q' to glyphs with CFF outlines
+	cffFont, err := os.ReadFile("testdata/CFFTest.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ttfFont, err := parseSFNT(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	woffFont, err := encodeWOFF(ttfFont)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		font    []byte
+		kept    string
+		dropped string
+	}{
+		{"truetype", goregular.TTF, "Ab &", "Zz"},
+		{"cff", cffFont, "0", "1Q"},
+		{"woff", woffFont, "Hello", "XYZ"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runes := make(map[rune]bool)
+
+			addRunes(runes, test.kept)
+
+			subset, err := subsetFont(test.font, runes)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(subset) >= len(test.font) {
+				t.Errorf("subset is %d bytes, no smaller than the %d byte font", len(subset), len(test.font))
+			}
+
+			original, err := parseTestFont(test.font)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := parseTestFont(subset)
+			if err != nil {
+				t.Fatalf("subset cannot be parsed: %v", err)
+			}
+
+			if parsed.NumGlyphs() != original.NumGlyphs() {
+				t.Errorf("subset has %d glyphs, want %d", parsed.NumGlyphs(), original.NumGlyphs())
+			}
+
+			var buffer sfnt.Buffer
+
+			for _, r := range test.kept {
+				index, err := parsed.GlyphIndex(&buffer, r)
+				if err != nil || index == 0 {
+					t.Errorf("rune %q is not mapped by the subset", r)
+					continue
+				}
+
+				// a space has no outline to keep
+				if r == ' ' {
+					continue
+				}
+
+				if segments, err := parsed.LoadGlyph(&buffer, index, fixed.I(12), nil); err != nil || len(segments) == 0 {
+					t.Errorf("outline of rune %q was not kept: %v", r, err)
+				}
+			}
+
+			for _, r := range test.dropped {
+				if index, _ := parsed.GlyphIndex(&buffer, r); index != 0 {
+					t.Errorf("rune %q is still mapped by the subset", r)
+				}
+
+				originalIndex, err := original.GlyphIndex(&buffer, r)
+				if err != nil || originalIndex == 0 {
+					t.Fatalf("rune %q is not in the font being subsetted", r)
+				}
+
+				if segments, _ := parsed.LoadGlyph(&buffer, originalIndex, fixed.I(12), nil); len(segments) != 0 {
+					t.Errorf("outline of rune %q was kept", r)
+				}
+			}
+		})
+	}
+}
+
+func parseTestFont(b []byte) (f *sfnt.Font, err error) {
+	if len(b) >= 4 && string(b[:4]) == "wOFF" {
+		if b, err = decodeWOFF(b); err != nil {
+			return
+		}
+	}
+
+	return sfnt.Parse(b)
+}
@@ -0,0 +1,410 @@
+package epub
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	hash "github.com/theTardigrade/golang-hash"
+)
+
+// FontSubset reports the result of subsetting a single embedded font.
+type FontSubset struct {
+	Path         string `json:"path"`
+	OriginalSize int    `json:"original_size"`
+	SubsetSize   int    `json:"subset_size"`
+	Cached       bool   `json:"cached"`
+}
+
+const (
+	// fontSubsetBaseText holds characters that reading systems may render
+	// without them appearing in the text, such as list numbers and hyphens.
+	fontSubsetBaseText = " 0123456789.-\u2010\u00ad"
+)
+
+var (
+	fontSubsetPseudoRegexp  = regexp.MustCompile(`::?[a-zA-Z-]+(\([^)]*\))?`)
+	fontSubsetContentRegexp = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"|'((?:[^'\\]|\\.)*)'`)
+)
+
+type fontSubsetDocument struct {
+	content     []byte
+	stylesheets []*epubInfoOutputStylesheet
+}
+
+type cssRule struct {
+	selector     string
+	declarations string
+}
+
+func epubInfoOutputInitFontSubsetting(ei *epubInfo) (err error) {
+	families := make(map[string]bool)
+
+	for _, font := range ei.output.fonts {
+		if font.ShouldSubset {
+			families[strings.ToLower(font.Family)] = true
+		}
+	}
+
+	if len(families) == 0 {
+		return
+	}
+
+	runesByFamily, err := ei.fontFamilyRunes(families)
+	if err != nil {
+		return
+	}
+
+	var data []*epubInfoOutputFileDatum
+
+	runesByDatum := make(map[*epubInfoOutputFileDatum]map[rune]bool)
+
+	for _, font := range ei.output.fonts {
+		if !font.ShouldSubset {
+			continue
+		}
+
+		runes, ok := runesByDatum[font.datum]
+		if !ok {
+			runes = make(map[rune]bool)
+			runesByDatum[font.datum] = runes
+			data = append(data, font.datum)
+		}
+
+		for r := range runesByFamily[strings.ToLower(font.Family)] {
+			runes[r] = true
+		}
+	}
+
+	for _, datum := range data {
+		key := "font_" + datum.hash + "_" + fontSubsetCacheKey(runesByDatum[datum])
+
+		subset, cached := ei.cache.load(key)
+
+		if !cached {
+			var subsetErr error
+
+			if subset, subsetErr = subsetFont(datum.content, runesByDatum[datum]); subsetErr != nil {
+				ei.warn(datum.sourcePath, 0, "cannot subset font: "+subsetErr.Error())
+				continue
+			}
+
+			ei.cache.store(key, subset)
+		}
+
+		fontSubset := FontSubset{
+			Path:         datum.sourcePath,
+			OriginalSize: len(datum.content),
+			Cached:       cached,
+		}
+
+		if len(subset) < len(datum.content) {
+			datum.content = subset
+		}
+
+		fontSubset.SubsetSize = len(datum.content)
+
+		ei.output.fontSubsets = append(ei.output.fontSubsets, fontSubset)
+	}
+
+	return
+}
+
+// fontFamilyRunes finds the characters that each of the given font families
+// may be used to render, by matching the rules of the book's stylesheets that
+// name a family against its pages. Every descendant of a matched element is
+// included, regardless of the cascade, so the result may be a superset.
+func (ei *epubInfo) fontFamilyRunes(families map[string]bool) (runesByFamily map[string]map[rune]bool, err error) {
+	runesByFamily = make(map[string]map[rune]bool)
+
+	for family := range families {
+		runesByFamily[family] = make(map[rune]bool)
+
+		addRunes(runesByFamily[family], fontSubsetBaseText)
+	}
+
+	documents, err := ei.fontSubsetDocuments()
+	if err != nil {
+		return
+	}
+
+	rulesByStylesheet := make(map[*epubInfoOutputStylesheet][]cssRule)
+
+	for _, document := range documents {
+		var doc *goquery.Document
+
+		if doc, err = goquery.NewDocumentFromReader(bytes.NewReader(document.content)); err != nil {
+			return
+		}
+
+		for _, stylesheet := range document.stylesheets {
+			rules, ok := rulesByStylesheet[stylesheet]
+			if !ok {
+				rules = parseCSSRules(string(stylesheet.content))
+				rulesByStylesheet[stylesheet] = rules
+			}
+
+			for _, rule := range rules {
+				matchedFamilies, content := cssDeclarationFamilies(rule.declarations, families)
+				if len(matchedFamilies) == 0 {
+					continue
+				}
+
+				text := content + fontSubsetSelectedText(doc, rule.selector)
+
+				for _, family := range matchedFamilies {
+					addRunes(runesByFamily[family], text)
+				}
+			}
+		}
+
+		doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+			matchedFamilies, content := cssDeclarationFamilies(s.AttrOr("style", ""), families)
+
+			for _, family := range matchedFamilies {
+				addRunes(runesByFamily[family], content+s.Text())
+			}
+		})
+	}
+
+	// text may be transformed to another case by stylesheets
+	for _, runes := range runesByFamily {
+		for r := range runes {
+			runes[unicode.ToUpper(r)] = true
+			runes[unicode.ToLower(r)] = true
+			runes[unicode.ToTitle(r)] = true
+		}
+	}
+
+	return
+}
+
+// fontSubsetDocuments returns every page of the book, other than the cover,
+// together with the stylesheets that apply to it.
+func (ei *epubInfo) fontSubsetDocuments() (documents []fontSubsetDocument, err error) {
+	for _, chapter := range ei.output.textChapters {
+		documents = append(documents, fontSubsetDocument{
+			content:     chapter.content,
+			stylesheets: appendStylesheets(appendStylesheets(nil, ei.output.styles...), chapter.stylesheets...),
+		})
+	}
+
+	templates := []*template.Template{ei.output.titleTemplate}
+
+	if ei.IncludeCopyrightPage {
+		templates = append(templates, ei.output.copyrightTemplate)
+	}
+
+	if ei.IncludeContentsPage {
+		templates = append(templates, ei.output.contentsTemplate)
+	}
+
+	for _, t := range templates {
+		var b []byte
+
+		if b, err = ei.executeTemplate(t); err != nil {
+			return
+		}
+
+		documents = append(documents, fontSubsetDocument{
+			content:     b,
+			stylesheets: ei.output.styles,
+		})
+	}
+
+	var navBuilder bytes.Buffer
+
+	navBuilder.WriteString(`<p>Cover Title Copyright Contents Text</p>`)
+
+	for _, heading := range ei.output.textHeadings {
+		navBuilder.WriteString(`<p>` + xmlEscape(heading.text) + `</p>`)
+	}
+
+	documents = append(documents, fontSubsetDocument{
+		content:     navBuilder.Bytes(),
+		stylesheets: ei.output.styles,
+	})
+
+	return
+}
+
+// fontSubsetSelectedText returns the text of the elements matched by
+// selector, ignoring any pseudo-classes and pseudo-elements, or the text of
+// the whole document if the selector cannot be understood.
+func fontSubsetSelectedText(doc *goquery.Document, selector string) string {
+	selector = fontSubsetPseudoRegexp.ReplaceAllString(selector, "")
+
+	matcher, err := cascadia.Compile(selector)
+	if err != nil {
+		return doc.Text()
+	}
+
+	var builder strings.Builder
+
+	doc.FindMatcher(matcher).Each(func(i int, s *goquery.Selection) {
+		builder.WriteString(s.Text())
+	})
+
+	return builder.String()
+}
+
+// cssDeclarationFamilies returns those of the given families that are named
+// by font-family or font declarations, along with the text of any generated
+// content declared alongside them.
+func cssDeclarationFamilies(declarations string, families map[string]bool) (matched []string, content string) {
+	for _, declaration := range splitCSS(declarations, ';') {
+		name, value, found := strings.Cut(declaration, ":")
+		if !found {
+			continue
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		switch name {
+		case "font-family", "font":
+			for _, candidate := range splitCSS(strings.ToLower(value), ',') {
+				candidate = strings.TrimSpace(strings.NewReplacer(`"`, ``, `'`, ``, `!important`, ``).Replace(candidate))
+
+				for family := range families {
+					if candidate == family || name == "font" && strings.HasSuffix(candidate, " "+family) {
+						matched = append(matched, family)
+					}
+				}
+			}
+		case "content":
+			for _, submatches := range fontSubsetContentRegexp.FindAllStringSubmatch(value, -1) {
+				content += submatches[1] + submatches[2]
+			}
+		}
+	}
+
+	return
+}
+
+// parseCSSRules returns the style rules of a stylesheet, including those
+// nested within conditional group rules such as @media.
+func parseCSSRules(css string) (rules []cssRule) {
+	for i := 0; i < len(css); {
+		j := indexCSS(css, i, "{;")
+		if j < 0 {
+			break
+		}
+
+		if css[j] == ';' {
+			i = j + 1
+			continue
+		}
+
+		end := indexCSSBlockEnd(css, j)
+		prelude := strings.TrimSpace(css[i:j])
+		block := css[j+1 : end]
+
+		if strings.HasPrefix(prelude, "@") {
+			switch keyword, _, _ := strings.Cut(strings.ToLower(prelude)+" ", " "); keyword {
+			case "@media", "@supports", "@document", "@layer":
+				rules = append(rules, parseCSSRules(block)...)
+			}
+		} else {
+			rules = append(rules, cssRule{
+				selector:     prelude,
+				declarations: block,
+			})
+		}
+
+		i = end + 1
+	}
+
+	return
+}
+
+// indexCSS returns the index of the first of chars found in css at or
+// after from, outside any string or parentheses, or -1 if there is none.
+func indexCSS(css string, from int, chars string) int {
+	var quote byte
+	var depth int
+
+	for i := from; i < len(css); i++ {
+		c := css[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth <= 0 && strings.IndexByte(chars, c) >= 0:
+			return i
+		}
+	}
+
+	return -1
+}
+
+// indexCSSBlockEnd returns the index of the brace that closes the block
+// opened at start, or the length of css if the block is not closed.
+func indexCSSBlockEnd(css string, start int) int {
+	depth := 0
+
+	for i := start; i < len(css); {
+		j := indexCSS(css, i, "{}")
+		if j < 0 {
+			break
+		}
+
+		if css[j] == '{' {
+			depth++
+		} else if depth--; depth == 0 {
+			return j
+		}
+
+		i = j + 1
+	}
+
+	return len(css)
+}
+
+func splitCSS(css string, separator byte) (parts []string) {
+	for {
+		i := indexCSS(css, 0, string(separator))
+		if i < 0 {
+			return append(parts, css)
+		}
+
+		parts = append(parts, css[:i])
+		css = css[i+1:]
+	}
+}
+
+// fontSubsetCacheKey identifies a set of runes, so that a font subsetted
+// to it need not be subsetted again when the book is rebuilt.
+func fontSubsetCacheKey(runes map[rune]bool) string {
+	sorted := make([]rune, 0, len(runes))
+
+	for r := range runes {
+		sorted = append(sorted, r)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	return hash.Uint256String(string(sorted)).Text(62)
+}
+
+func addRunes(runes map[rune]bool, text string) {
+	for _, r := range text {
+		runes[r] = true
+	}
+}
@@ -0,0 +1,647 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type generateZipHandler func(*epubInfo, *zip.Writer) error
+
+var (
+	generateZipHandlerList = []generateZipHandler{
+		generateZipMimetype,
+		generateZipContainer,
+		generateZipEncryption,
+		generateZipFiles,
+		generateZipStyles,
+		generateZipCoverImage,
+		generateZipCoverPage,
+		generateZipTitlePage,
+		generateZipCopyrightPage,
+		generateZipContentsPage,
+		generateZipTextPages,
+		generateZipNav,
+		generateZipOCF,
+		generateZipNCX,
+	}
+)
+
+func generateZip(ctx context.Context, ei *epubInfo, w io.Writer) (err error) {
+	archiveWriter := zip.NewWriter(w)
+
+	for _, handler := range generateZipHandlerList {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		if err = handler(ei, archiveWriter); err != nil {
+			return
+		}
+	}
+
+	if err = archiveWriter.Close(); err != nil {
+		return
+	}
+
+	return
+}
+
+func generateZipMimetype(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	w, err := archiveWriter.CreateHeader(&zip.FileHeader{
+		Name:   "mimetype",
+		Method: zip.Store,
+	})
+	if err != nil {
+		return
+	}
+
+	if _, err = io.WriteString(w, "application/epub+zip"); err != nil {
+		return
+	}
+
+	return
+}
+
+func generateZipFiles(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	for _, datum := range ei.output.fileData {
+		content := datum.content
+		header := &zip.FileHeader{
+			Name:   datum.path,
+			Method: zip.Deflate,
+		}
+
+		// obfuscation scrambles the start of the font, so it is stored
+		// rather than compressed, as reading systems expect
+		if datum.obfuscated {
+			content = ei.obfuscateFont(content)
+			header.Method = zip.Store
+		}
+
+		w, err := archiveWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if _, err = w.Write(content); err != nil {
+			return err
+		}
+	}
+
+	return
+}
+
+func generateZipContainer(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	w, err := archiveWriter.Create("META-INF/container.xml")
+	if err != nil {
+		return
+	}
+
+	var contentBuilder strings.Builder
+
+	contentBuilder.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	contentBuilder.WriteString(`<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">`)
+	contentBuilder.WriteString(`<rootfiles>`)
+	contentBuilder.WriteString(`<rootfile full-path="content.opf" media-type="application/oebps-package+xml" />`)
+	contentBuilder.WriteString(`</rootfiles>`)
+	contentBuilder.WriteString(`</container>`)
+
+	if _, err = io.WriteString(w, contentBuilder.String()); err != nil {
+		return
+	}
+
+	return
+}
+
+func generateZipStyles(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	for _, stylesheet := range ei.output.stylesheets {
+		var w io.Writer
+
+		if w, err = archiveWriter.Create(stylesheet.path); err != nil {
+			return
+		}
+
+		if _, err = w.Write(stylesheet.content); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func generateZipCoverImage(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	if ei.output.coverImage == nil {
+		return
+	}
+
+	w, err := archiveWriter.Create(ei.output.coverImage.path())
+	if err != nil {
+		return
+	}
+
+	if _, err = w.Write(ei.output.coverImage.content); err != nil {
+		return
+	}
+
+	return
+}
+
+func generateZipCoverPage(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	if ei.output.coverImage == nil {
+		return
+	}
+
+	w, err := archiveWriter.Create("cover.xhtml")
+	if err != nil {
+		return
+	}
+
+	var headerBuilder, bodyBuilder bytes.Buffer
+	coverImageWidthString := strconv.Itoa(ei.output.coverImage.width)
+	coverImageHeightString := strconv.Itoa(ei.output.coverImage.height)
+
+	headerBuilder.WriteString(`<style type="text/css">`)
+	headerBuilder.WriteString(`@page{padding:0pt !important;margin:0pt !important;}`)
+	headerBuilder.WriteString(`body{text-align:center !important;padding:0pt !important;margin:0pt !important;}`)
+	headerBuilder.WriteString(`</style>`)
+
+	bodyBuilder.WriteString(`<div class="page cover_page">`)
+	bodyBuilder.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" width="100%" height="100%" viewBox="0 0 ` + coverImageWidthString + ` ` + coverImageHeightString + `" preserveAspectRatio="none">`)
+	bodyBuilder.WriteString(`<image width="` + coverImageWidthString + `" height="` + coverImageHeightString + `" xlink:href="` + ei.output.coverImage.path() + `" />`)
+	bodyBuilder.WriteString(`</svg>`)
+	bodyBuilder.WriteString(`</div>`)
+
+	if _, err = io.WriteString(w, ei.xhtmlHeader(ei.output.language.String(), ei.output.direction, "Cover", ei.stylesheetPaths(), headerBuilder.String())); err != nil {
+		return
+	}
+
+	if _, err = w.Write(bodyBuilder.Bytes()); err != nil {
+		return
+	}
+
+	if _, err = io.WriteString(w, xhtmlFooter()); err != nil {
+		return
+	}
+
+	return
+}
+
+func generateZipTitlePage(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	return generateZipTemplatePage(ei, archiveWriter, "title.xhtml", "Title", ei.output.titleTemplate)
+}
+
+func generateZipCopyrightPage(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	if !ei.IncludeCopyrightPage {
+		return
+	}
+
+	return generateZipTemplatePage(ei, archiveWriter, "copyright.xhtml", "Copyright", ei.output.copyrightTemplate)
+}
+
+func generateZipContentsPage(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	if !ei.IncludeContentsPage {
+		return
+	}
+
+	return generateZipTemplatePage(ei, archiveWriter, "contents.xhtml", "Contents", ei.output.contentsTemplate)
+}
+
+func generateZipTemplatePage(ei *epubInfo, archiveWriter *zip.Writer, path, title string, t *template.Template) (err error) {
+	b, err := ei.executeTemplate(t)
+	if err != nil {
+		return
+	}
+
+	w, err := archiveWriter.Create(path)
+	if err != nil {
+		return
+	}
+
+	if _, err = io.WriteString(w, ei.xhtmlHeader(ei.output.language.String(), ei.output.direction, title, ei.stylesheetPaths(), "")); err != nil {
+		return
+	}
+
+	if _, err = w.Write(b); err != nil {
+		return
+	}
+
+	if _, err = io.WriteString(w, xhtmlFooter()); err != nil {
+		return
+	}
+
+	return
+}
+
+func generateZipTextPages(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	for _, chapter := range ei.output.textChapters {
+		if err = generateZipTextPage(ei, archiveWriter, chapter); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func generateZipTextPage(ei *epubInfo, archiveWriter *zip.Writer, chapter *epubInfoOutputTextChapter) (err error) {
+	w, err := archiveWriter.Create(chapter.path)
+	if err != nil {
+		return
+	}
+
+	var bodyBuilder bytes.Buffer
+
+	bodyBuilder.WriteString(`<div class="page text_page">`)
+	bodyBuilder.Write(chapter.content)
+	bodyBuilder.WriteString(`</div>`)
+
+	if _, err = io.WriteString(w, ei.xhtmlHeader(chapter.language.String(), chapter.direction, chapter.title, ei.stylesheetPaths(chapter.stylesheets...), "")); err != nil {
+		return
+	}
+
+	if _, err = w.Write(bodyBuilder.Bytes()); err != nil {
+		return
+	}
+
+	if _, err = io.WriteString(w, xhtmlFooter()); err != nil {
+		return
+	}
+
+	return
+}
+
+func generateZipNav(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	if !ei.isEPUB3() {
+		return
+	}
+
+	w, err := archiveWriter.Create("nav.xhtml")
+	if err != nil {
+		return
+	}
+
+	var builder bytes.Buffer
+
+	builder.WriteString(`<nav epub:type="toc" id="toc">`)
+	builder.WriteString(`<h1>Contents</h1>`)
+	builder.WriteString(`<ol>`)
+
+	if ei.output.coverImage != nil {
+		builder.WriteString(`<li><a href="cover.xhtml">Cover</a></li>`)
+	}
+
+	builder.WriteString(`<li><a href="title.xhtml">Title</a></li>`)
+
+	if ei.IncludeCopyrightPage {
+		builder.WriteString(`<li><a href="copyright.xhtml">Copyright</a></li>`)
+	}
+
+	if ei.IncludeContentsPage {
+		builder.WriteString(`<li><a href="contents.xhtml">Contents</a></li>`)
+	}
+
+	if len(ei.output.textHeadingsTree) > 0 {
+		generateHeadingsListItems(&builder, ei.output.textHeadingsTree)
+	} else {
+		builder.WriteString(`<li><a href="` + ei.output.textChapters[0].path + `">Text</a></li>`)
+	}
+
+	builder.WriteString(`</ol>`)
+	builder.WriteString(`</nav>`)
+
+	builder.WriteString(`<nav epub:type="landmarks" id="landmarks" hidden="hidden">`)
+	builder.WriteString(`<h2>Landmarks</h2>`)
+	builder.WriteString(`<ol>`)
+
+	if ei.output.coverImage != nil {
+		builder.WriteString(`<li><a epub:type="cover" href="cover.xhtml">Cover</a></li>`)
+	}
+
+	builder.WriteString(`<li><a epub:type="titlepage" href="title.xhtml">Title</a></li>`)
+
+	if ei.IncludeCopyrightPage {
+		builder.WriteString(`<li><a epub:type="copyright-page" href="copyright.xhtml">Copyright</a></li>`)
+	}
+
+	if ei.IncludeContentsPage {
+		builder.WriteString(`<li><a epub:type="toc" href="contents.xhtml">Contents</a></li>`)
+	}
+
+	builder.WriteString(`<li><a epub:type="bodymatter" href="` + ei.output.textChapters[0].path + `">Text</a></li>`)
+	builder.WriteString(`</ol>`)
+	builder.WriteString(`</nav>`)
+
+	if _, err = io.WriteString(w, ei.xhtmlHeader(ei.output.language.String(), ei.output.direction, "Contents", ei.stylesheetPaths(), "")); err != nil {
+		return
+	}
+
+	if _, err = w.Write(builder.Bytes()); err != nil {
+		return
+	}
+
+	if _, err = io.WriteString(w, xhtmlFooter()); err != nil {
+		return
+	}
+
+	return
+}
+
+func generateZipOCF(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	w, err := archiveWriter.Create("content.opf")
+	if err != nil {
+		return
+	}
+
+	var builder strings.Builder
+
+	packageVersion := "2.0"
+
+	if ei.isEPUB3() {
+		packageVersion = "3.0"
+	}
+
+	builder.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	builder.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" version="` + packageVersion + `" unique-identifier="unique-id">`)
+	builder.WriteString(`<metadata xmlns:opf="http://www.idpf.org/2007/opf" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:calibre="http://calibre.kovidgoyal.net/2009/metadata">`)
+	builder.WriteString(`<dc:language>` + xmlEscape(ei.output.language.String()) + `</dc:language>`)
+	builder.WriteString(`<dc:title>` + xmlEscape(ei.Title) + `</dc:title>`)
+	generateOCFIdentifiers(ei, &builder)
+	generateOCFMetadata(ei, &builder)
+
+	if ei.isEPUB3() {
+		builder.WriteString(`<meta property="dcterms:modified">` + ei.output.modified.Format("2006-01-02T15:04:05Z") + `</meta>`)
+	}
+
+	builder.WriteString(`</metadata>`)
+	builder.WriteString(`<manifest>`)
+
+	for i, datum := range ei.output.fileData {
+		builder.WriteString(`<item id="file_` + strconv.Itoa(i) + `" href="` + xmlEscape(datum.path) + `" media-type="` + xmlEscape(datum.mimeType) + `" />`)
+	}
+
+	for _, stylesheet := range ei.output.stylesheets {
+		builder.WriteString(`<item id="` + stylesheet.id + `" href="` + stylesheet.path + `" media-type="text/css" />`)
+	}
+
+	if ei.output.coverImage != nil {
+		if ei.isEPUB3() {
+			builder.WriteString(`<item id="cover_image" href="` + ei.output.coverImage.path() + `" media-type="` + ei.output.coverImage.mediaType() + `" properties="cover-image" />`)
+			builder.WriteString(`<item id="cover_page" href="cover.xhtml" media-type="application/xhtml+xml" properties="svg" />`)
+		} else {
+			builder.WriteString(`<item id="cover_image" href="` + ei.output.coverImage.path() + `" media-type="` + ei.output.coverImage.mediaType() + `" />`)
+			builder.WriteString(`<item id="cover_page" href="cover.xhtml" media-type="application/xhtml+xml" />`)
+		}
+	}
+
+	for _, page := range []struct {
+		id       string
+		path     string
+		template *template.Template
+		included bool
+	}{
+		{"title_page", "title.xhtml", ei.output.titleTemplate, true},
+		{"copyright_page", "copyright.xhtml", ei.output.copyrightTemplate, ei.IncludeCopyrightPage},
+		{"contents_page", "contents.xhtml", ei.output.contentsTemplate, ei.IncludeContentsPage},
+	} {
+		if !page.included {
+			continue
+		}
+
+		var b []byte
+
+		if b, err = ei.executeTemplate(page.template); err != nil {
+			return
+		}
+
+		builder.WriteString(`<item id="` + page.id + `" href="` + page.path + `" media-type="application/xhtml+xml"` + ei.manifestProperties(b) + ` />`)
+	}
+
+	for _, chapter := range ei.output.textChapters {
+		builder.WriteString(`<item id="` + chapter.id + `" href="` + chapter.path + `" media-type="application/xhtml+xml"` + ei.manifestProperties(chapter.content) + ` />`)
+	}
+
+	builder.WriteString(`<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml" />`)
+
+	if ei.isEPUB3() {
+		builder.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav" />`)
+	}
+
+	builder.WriteString(`</manifest>`)
+	if ei.isEPUB3() && ei.output.direction == DirectionRightToLeft {
+		builder.WriteString(`<spine toc="ncx" page-progression-direction="rtl">`)
+	} else {
+		builder.WriteString(`<spine toc="ncx">`)
+	}
+
+	if ei.output.coverImage != nil {
+		builder.WriteString(`<itemref idref="cover_page" />`)
+	}
+
+	builder.WriteString(`<itemref idref="title_page" />`)
+
+	if ei.IncludeCopyrightPage {
+		builder.WriteString(`<itemref idref="copyright_page" />`)
+	}
+
+	if ei.IncludeContentsPage {
+		builder.WriteString(`<itemref idref="contents_page" />`)
+	}
+
+	for _, chapter := range ei.output.textChapters {
+		builder.WriteString(`<itemref idref="` + chapter.id + `" />`)
+	}
+
+	builder.WriteString(`</spine>`)
+
+	if ei.output.coverImage != nil {
+		builder.WriteString(`<guide>`)
+		builder.WriteString(`<reference type="cover_page" href="cover.xhtml" title="Cover" />`)
+		builder.WriteString(`</guide>`)
+	}
+
+	builder.WriteString(`</package>`)
+
+	if _, err = io.WriteString(w, builder.String()); err != nil {
+		return
+	}
+
+	return
+}
+
+// manifestProperties returns the properties attribute, if one is needed,
+// of the manifest item of a content document with the given body content.
+// EPUB 2 has no such attribute.
+func (ei *epubInfo) manifestProperties(content []byte) string {
+	if !ei.isEPUB3() {
+		return ""
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
+	if err != nil {
+		return ""
+	}
+
+	var properties []string
+
+	if doc.Find("math").Length() > 0 {
+		properties = append(properties, "mathml")
+	}
+
+	remote := doc.Find("img, audio, video, source, image").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		for _, attr := range []string{"src", "href", "xlink:href"} {
+			if isExternalReference(s.AttrOr(attr, "")) {
+				return true
+			}
+		}
+
+		return false
+	})
+
+	if remote.Length() > 0 {
+		properties = append(properties, "remote-resources")
+	}
+
+	if doc.Find("script").Length() > 0 {
+		properties = append(properties, "scripted")
+	}
+
+	if doc.Find("svg").Length() > 0 {
+		properties = append(properties, "svg")
+	}
+
+	if len(properties) == 0 {
+		return ""
+	}
+
+	return ` properties="` + strings.Join(properties, " ") + `"`
+}
+
+func generateZipNCX(ei *epubInfo, archiveWriter *zip.Writer) (err error) {
+	w, err := archiveWriter.Create("toc.ncx")
+	if err != nil {
+		return
+	}
+
+	var contentBuilder strings.Builder
+	var playOrder int
+
+	depth := headingsDepth(ei.output.textHeadingsTree)
+
+	if depth == 0 {
+		depth = 1
+	}
+
+	contentBuilder.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	contentBuilder.WriteString(`<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1" xml:lang="` + xmlEscape(ei.output.language.String()) + `">`)
+	contentBuilder.WriteString(`<head>`)
+	contentBuilder.WriteString(`<meta name="dtb:uid" content="` + xmlEscape(ei.output.uniqueIdentifier) + `" />`)
+	contentBuilder.WriteString(`<meta name="dtb:depth" content="` + strconv.Itoa(depth) + `" />`)
+	contentBuilder.WriteString(`<meta name="dtb:totalPageCount" content="0" />`)
+	contentBuilder.WriteString(`<meta name="dtb:maxPageNumber" content="0" />`)
+	contentBuilder.WriteString(`</head>`)
+	contentBuilder.WriteString(`<docTitle>`)
+	contentBuilder.WriteString(`<text>` + xmlEscape(ei.Title) + "</text>")
+	contentBuilder.WriteString(`</docTitle>`)
+	contentBuilder.WriteString(`<navMap>`)
+
+	if ei.output.coverImage != nil {
+		playOrder++
+		contentBuilder.WriteString(`<navPoint id="cover_page" playOrder="` + strconv.Itoa(playOrder) + `">`)
+		contentBuilder.WriteString(`<navLabel>`)
+		contentBuilder.WriteString(`<text>Cover</text>`)
+		contentBuilder.WriteString(`</navLabel>`)
+		contentBuilder.WriteString(`<content src="cover.xhtml" />`)
+		contentBuilder.WriteString(`</navPoint>`)
+	}
+
+	playOrder++
+	contentBuilder.WriteString(`<navPoint id="title_page" playOrder="` + strconv.Itoa(playOrder) + `">`)
+	contentBuilder.WriteString(`<navLabel>`)
+	contentBuilder.WriteString(`<text>Title</text>`)
+	contentBuilder.WriteString(`</navLabel>`)
+	contentBuilder.WriteString(`<content src="title.xhtml" />`)
+	contentBuilder.WriteString(`</navPoint>`)
+
+	if ei.IncludeCopyrightPage {
+		playOrder++
+		contentBuilder.WriteString(`<navPoint id="copyright_page" playOrder="` + strconv.Itoa(playOrder) + `">`)
+		contentBuilder.WriteString(`<navLabel>`)
+		contentBuilder.WriteString(`<text>Copyright</text>`)
+		contentBuilder.WriteString(`</navLabel>`)
+		contentBuilder.WriteString(`<content src="copyright.xhtml" />`)
+		contentBuilder.WriteString(`</navPoint>`)
+	}
+
+	if ei.IncludeContentsPage {
+		playOrder++
+		contentBuilder.WriteString(`<navPoint id="contents_page" playOrder="` + strconv.Itoa(playOrder) + `">`)
+		contentBuilder.WriteString(`<navLabel>`)
+		contentBuilder.WriteString(`<text>Contents</text>`)
+		contentBuilder.WriteString(`</navLabel>`)
+		contentBuilder.WriteString(`<content src="contents.xhtml" />`)
+		contentBuilder.WriteString(`</navPoint>`)
+	}
+
+	if len(ei.output.textHeadingsTree) > 0 {
+		generateHeadingsNavPoints(&contentBuilder, ei.output.textHeadingsTree, &playOrder)
+	} else {
+		playOrder++
+		contentBuilder.WriteString(`<navPoint id="text_page" playOrder="` + strconv.Itoa(playOrder) + `">`)
+		contentBuilder.WriteString(`<navLabel>`)
+		contentBuilder.WriteString(`<text>Text</text>`)
+		contentBuilder.WriteString(`</navLabel>`)
+		contentBuilder.WriteString(`<content src="` + ei.output.textChapters[0].path + `" />`)
+		contentBuilder.WriteString(`</navPoint>`)
+	}
+
+	contentBuilder.WriteString(`</navMap>`)
+	contentBuilder.WriteString(`</ncx>`)
+
+	if _, err = io.WriteString(w, contentBuilder.String()); err != nil {
+		return
+	}
+
+	return
+}
+
+func generateHeadingsListItems(builder *bytes.Buffer, headings []*epubInfoOutputTextHeading) {
+	for _, heading := range headings {
+		builder.WriteString(`<li><a href="` + xmlEscape(heading.href()) + `">` + xmlEscape(heading.text) + `</a>`)
+
+		if len(heading.children) > 0 {
+			builder.WriteString(`<ol>`)
+			generateHeadingsListItems(builder, heading.children)
+			builder.WriteString(`</ol>`)
+		}
+
+		builder.WriteString(`</li>`)
+	}
+}
+
+func generateHeadingsNavPoints(builder *strings.Builder, headings []*epubInfoOutputTextHeading, playOrder *int) {
+	for _, heading := range headings {
+		*playOrder++
+		builder.WriteString(`<navPoint id="text_page_` + heading.id + `" playOrder="` + strconv.Itoa(*playOrder) + `">`)
+		builder.WriteString(`<navLabel>`)
+		builder.WriteString(`<text>` + xmlEscape(heading.text) + `</text>`)
+		builder.WriteString(`</navLabel>`)
+		builder.WriteString(`<content src="` + xmlEscape(heading.href()) + `" />`)
+		generateHeadingsNavPoints(builder, heading.children, playOrder)
+		builder.WriteString(`</navPoint>`)
+	}
+}
+
+func headingsDepth(headings []*epubInfoOutputTextHeading) (depth int) {
+	for _, heading := range headings {
+		if childDepth := headingsDepth(heading.children) + 1; childDepth > depth {
+			depth = childDepth
+		}
+	}
+
+	return
+}
@@ -0,0 +1,85 @@
+package epub
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestGenerateManifestProperties(t *testing.T) {
+	const text = `<html><body>
+<h1>Remote</h1>
+<p><img src="https://example.com/image.png" alt="remote"/></p>
+<h1>Drawing</h1>
+<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10"/></svg>
+<h1>Plain</h1>
+<aside epub:type="footnote" id="note"><p>A note.</p></aside>
+</body></html>`
+
+	tests := []struct {
+		version    int
+		properties map[string]string
+	}{
+		{2, map[string]string{"chapter_1": "", "chapter_2": "", "chapter_3": ""}},
+		{3, map[string]string{"chapter_1": "remote-resources", "chapter_2": "svg", "chapter_3": "", "title_page": ""}},
+	}
+
+	for _, test := range tests {
+		r := buildTestBook(t, map[string]string{"text.html": text}, Options{
+			EPUBVersion: test.version,
+			Title:       "Properties",
+		})
+
+		problems, err := ValidateReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, problem := range problems {
+			t.Errorf("EPUB %d: %s", test.version, problem)
+		}
+
+		var pkg struct {
+			Items []struct {
+				ID         string `xml:"id,attr"`
+				Properties string `xml:"properties,attr"`
+			} `xml:"manifest>item"`
+		}
+
+		if err := xml.Unmarshal([]byte(readTestArchiveFile(t, r, "content.opf")), &pkg); err != nil {
+			t.Fatal(err)
+		}
+
+		properties := make(map[string]string)
+
+		for _, item := range pkg.Items {
+			properties[item.ID] = item.Properties
+		}
+
+		for id, want := range test.properties {
+			if got, ok := properties[id]; !ok || got != want {
+				t.Errorf("EPUB %d: %s has properties %q, want %q", test.version, id, got, want)
+			}
+		}
+
+		for _, f := range r.File {
+			if !strings.HasSuffix(f.Name, ".xhtml") {
+				continue
+			}
+
+			content := readTestArchiveFile(t, r, f.Name)
+
+			if hasNamespace := strings.Contains(content, `xmlns:epub=`); hasNamespace != (test.version == 3) {
+				t.Errorf("EPUB %d: %s declares the epub namespace: %t", test.version, f.Name, hasNamespace)
+			}
+
+			if test.version == 2 && strings.Contains(content, "epub:") {
+				t.Errorf("EPUB %d: %s uses the epub namespace", test.version, f.Name)
+			}
+		}
+
+		if chapter := readTestArchiveFile(t, r, "chapter_3.xhtml"); test.version == 3 && !strings.Contains(chapter, `epub:type="footnote"`) {
+			t.Errorf("EPUB %d: chapter_3.xhtml has lost its epub:type attribute", test.version)
+		}
+	}
+}
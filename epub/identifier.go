@@ -0,0 +1,198 @@
+package epub
+
+import (
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	hash "github.com/theTardigrade/golang-hash"
+)
+
+// Identifier is a unique identifier for a book under a given scheme,
+// such as "ISBN", "UUID", "DOI" or "ASIN".
+type Identifier struct {
+	Scheme string `json:"scheme"`
+	Value  string `json:"value"`
+}
+
+const (
+	IdentifierSchemeISBN = "ISBN"
+	IdentifierSchemeUUID = "UUID"
+	IdentifierSchemeDOI  = "DOI"
+	IdentifierSchemeASIN = "ASIN"
+)
+
+var (
+	identifierUUIDRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	identifierDOIRegexp  = regexp.MustCompile(`^10\.[0-9]{4,}(\.[0-9]+)*/\S+$`)
+	identifierASINRegexp = regexp.MustCompile(`^[0-9A-Z]{10}$`)
+)
+
+func epubInfoOutputInitIdentifiers(ei *epubInfo) (err error) {
+	if ei.ISBN != "" {
+		ei.output.identifiers = append(ei.output.identifiers, Identifier{
+			Scheme: IdentifierSchemeISBN,
+			Value:  ei.ISBN,
+		})
+	}
+
+	for _, identifier := range ei.Identifiers {
+		identifier.Scheme = strings.ToUpper(identifier.Scheme)
+
+		ei.output.identifiers = append(ei.output.identifiers, identifier)
+	}
+
+	for _, identifier := range ei.output.identifiers {
+		if validateErr := validateIdentifier(identifier); validateErr != nil {
+			ei.failOption(`"`+identifier.Value+`"`, validateErr.Error())
+		}
+	}
+
+	if len(ei.output.identifiers) == 0 {
+		ei.output.identifiers = append(ei.output.identifiers, Identifier{
+			Scheme: IdentifierSchemeUUID,
+			Value:  generatedUUID(ei.Title, ei.Author, strconv.Itoa(ei.EditionNumber)),
+		})
+	}
+
+	ei.output.uniqueIdentifier = ei.identifierValue(ei.output.identifiers[0])
+
+	return
+}
+
+func validateIdentifier(identifier Identifier) (err error) {
+	switch identifier.Scheme {
+	case IdentifierSchemeISBN:
+		return validateISBN(identifier.Value)
+	case IdentifierSchemeUUID:
+		if !identifierUUIDRegexp.MatchString(identifier.Value) {
+			return errors.New("invalid UUID: " + identifier.Value)
+		}
+	case IdentifierSchemeDOI:
+		if !identifierDOIRegexp.MatchString(identifier.Value) {
+			return errors.New("invalid DOI: " + identifier.Value)
+		}
+	case IdentifierSchemeASIN:
+		if !identifierASINRegexp.MatchString(identifier.Value) {
+			return errors.New("invalid ASIN: " + identifier.Value)
+		}
+	default:
+		return errors.New("unsupported identifier scheme: " + identifier.Scheme)
+	}
+
+	return
+}
+
+func validateISBN(isbn string) (err error) {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(isbn)
+
+	switch len(digits) {
+	case 10:
+		var sum int
+
+		for i, r := range digits[:9] {
+			if r < '0' || r > '9' {
+				return errors.New("invalid character in ISBN-10: " + isbn)
+			}
+
+			sum += int(r-'0') * (10 - i)
+		}
+
+		expected := "X"
+
+		if checkDigit := (11 - sum%11) % 11; checkDigit < 10 {
+			expected = strconv.Itoa(checkDigit)
+		}
+
+		if actual := strings.ToUpper(digits[9:]); actual != expected {
+			return errors.New("invalid ISBN-10 check digit in " + isbn + ": expected " + expected + " but found " + actual)
+		}
+	case 13:
+		var sum int
+
+		for i, r := range digits[:12] {
+			if r < '0' || r > '9' {
+				return errors.New("invalid character in ISBN-13: " + isbn)
+			}
+
+			value := int(r - '0')
+
+			if i%2 == 1 {
+				value *= 3
+			}
+
+			sum += value
+		}
+
+		expected := strconv.Itoa((10 - sum%10) % 10)
+
+		if actual := digits[12:]; actual != expected {
+			return errors.New("invalid ISBN-13 check digit in " + isbn + ": expected " + expected + " but found " + actual)
+		}
+	default:
+		return errors.New("ISBN must have 10 or 13 digits: " + isbn)
+	}
+
+	return
+}
+
+// generatedUUID derives a stable UUID from the given parts,
+// so that rebuilding the same book always yields the same identifier.
+func generatedUUID(parts ...string) string {
+	var b [16]byte
+
+	hash.Uint128String(strings.Join(parts, "\x00")).FillBytes(b[:])
+
+	b[6] = b[6]&0x0f | 0x80 // version 8
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+
+	s := hex.EncodeToString(b[:])
+
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// identifierValue returns the form of an identifier written to the package
+// document: a URI for EPUB 3, where no scheme attribute exists, and the bare
+// value for EPUB 2 apart from UUIDs, which are conventionally written as URNs.
+func (ei *epubInfo) identifierValue(identifier Identifier) string {
+	if ei.isEPUB3() || identifier.Scheme == IdentifierSchemeUUID {
+		return identifierURI(identifier)
+	}
+
+	return identifier.Value
+}
+
+func identifierURI(identifier Identifier) string {
+	switch identifier.Scheme {
+	case IdentifierSchemeISBN:
+		return "urn:isbn:" + strings.NewReplacer("-", "", " ", "").Replace(identifier.Value)
+	case IdentifierSchemeUUID:
+		return "urn:uuid:" + strings.ToLower(identifier.Value)
+	case IdentifierSchemeDOI:
+		return "doi:" + identifier.Value
+	}
+
+	return identifier.Value
+}
+
+func generateOCFIdentifiers(ei *epubInfo, builder *strings.Builder) {
+	for i, identifier := range ei.output.identifiers {
+		id := "identifier_" + strconv.Itoa(i+1)
+
+		if i == 0 {
+			id = "unique-id"
+		}
+
+		if ei.isEPUB3() {
+			builder.WriteString(`<dc:identifier id="` + id + `">` + xmlEscape(ei.identifierValue(identifier)) + `</dc:identifier>`)
+
+			if identifier.Scheme == IdentifierSchemeASIN {
+				builder.WriteString(`<meta refines="#` + id + `" property="identifier-type">` + identifier.Scheme + `</meta>`)
+			}
+		} else {
+			builder.WriteString(`<dc:identifier id="` + id + `" opf:scheme="` + identifier.Scheme + `">` + xmlEscape(ei.identifierValue(identifier)) + `</dc:identifier>`)
+		}
+	}
+}
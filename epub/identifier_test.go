@@ -0,0 +1,41 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateISBN(t *testing.T) {
+	tests := []struct {
+		isbn  string
+		error string
+	}{
+		{"9780306406157", ""},
+		{"978-0-306-40615-7", ""},
+		{"978 0 306 40615 7", ""},
+		{"0306406152", ""},
+		{"0-306-40615-2", ""},
+		{"080442957X", ""},
+		{"080442957x", ""},
+		{"9780306406158", "check digit"},
+		{"0306406153", "check digit"},
+		{"978030640615X", "check digit"},
+		{"97803064A6157", "invalid character"},
+		{"03064A6152", "invalid character"},
+		{"12345", "10 or 13 digits"},
+		{"", "10 or 13 digits"},
+	}
+
+	for _, test := range tests {
+		err := validateISBN(test.isbn)
+
+		switch {
+		case test.error == "" && err != nil:
+			t.Errorf("validateISBN(%q) = %v, want no error", test.isbn, err)
+		case test.error != "" && err == nil:
+			t.Errorf("validateISBN(%q) returned no error, want one about %q", test.isbn, test.error)
+		case test.error != "" && !strings.Contains(err.Error(), test.error):
+			t.Errorf("validateISBN(%q) = %v, want an error about %q", test.isbn, err, test.error)
+		}
+	}
+}
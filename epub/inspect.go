@@ -0,0 +1,173 @@
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+)
+
+// Inspection summarizes the package document of an EPUB archive.
+type Inspection struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	Language    string           `json:"language"`
+	Identifiers []string         `json:"identifiers"`
+	Creators    []string         `json:"creators"`
+	Publisher   string           `json:"publisher"`
+	Date        string           `json:"date"`
+	Modified    string           `json:"modified"`
+	Manifest    []InspectionItem `json:"manifest"`
+	Spine       []string         `json:"spine"`
+}
+
+// InspectionItem describes a single file listed in the manifest
+// of an EPUB archive.
+type InspectionItem struct {
+	Path           string `json:"path"`
+	MediaType      string `json:"media_type"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressed_size"`
+}
+
+type inspectPackage struct {
+	Version  string `xml:"version,attr"`
+	Metadata struct {
+		Titles      []string `xml:"title"`
+		Languages   []string `xml:"language"`
+		Identifiers []string `xml:"identifier"`
+		Creators    []string `xml:"creator"`
+		Publisher   string   `xml:"publisher"`
+		Date        string   `xml:"date"`
+		Metas       []struct {
+			Property string `xml:"property,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest struct {
+		Items []struct {
+			ID        string `xml:"id,attr"`
+			Href      string `xml:"href,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"item"`
+	} `xml:"manifest"`
+	Spine struct {
+		Itemrefs []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// Inspect opens the EPUB archive found at path and summarizes it.
+func Inspect(path string) (inspection *Inspection, err error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	return InspectReader(&r.Reader)
+}
+
+// InspectReader summarizes an EPUB archive.
+func InspectReader(r *zip.Reader) (inspection *Inspection, err error) {
+	files := make(map[string]*zip.File, len(r.File))
+
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+
+	var container validateContainer
+
+	if err = inspectDecode(files, "META-INF/container.xml", &container); err != nil {
+		return
+	}
+
+	if len(container.Rootfiles) == 0 {
+		return nil, errors.New("container.xml has no rootfile")
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg inspectPackage
+
+	if err = inspectDecode(files, opfPath, &pkg); err != nil {
+		return
+	}
+
+	inspection = &Inspection{
+		Version:     pkg.Version,
+		Identifiers: pkg.Metadata.Identifiers,
+		Creators:    pkg.Metadata.Creators,
+		Publisher:   pkg.Metadata.Publisher,
+		Date:        pkg.Metadata.Date,
+	}
+
+	if len(pkg.Metadata.Titles) > 0 {
+		inspection.Title = pkg.Metadata.Titles[0]
+	}
+
+	if len(pkg.Metadata.Languages) > 0 {
+		inspection.Language = pkg.Metadata.Languages[0]
+	}
+
+	for _, meta := range pkg.Metadata.Metas {
+		if meta.Property == "dcterms:modified" {
+			inspection.Modified = meta.Value
+		}
+	}
+
+	hrefs := make(map[string]string, len(pkg.Manifest.Items))
+	dir := path.Dir(opfPath)
+
+	for _, item := range pkg.Manifest.Items {
+		itemPath, ok := validateResolve(dir, item.Href)
+		if !ok {
+			itemPath = item.Href
+		}
+
+		inspectionItem := InspectionItem{
+			Path:      itemPath,
+			MediaType: item.MediaType,
+		}
+
+		if f, ok := files[itemPath]; ok {
+			inspectionItem.Size = int64(f.UncompressedSize64)
+			inspectionItem.CompressedSize = int64(f.CompressedSize64)
+		}
+
+		hrefs[item.ID] = itemPath
+		inspection.Manifest = append(inspection.Manifest, inspectionItem)
+	}
+
+	for _, itemref := range pkg.Spine.Itemrefs {
+		inspection.Spine = append(inspection.Spine, hrefs[itemref.IDRef])
+	}
+
+	return
+}
+
+func inspectDecode(files map[string]*zip.File, name string, v interface{}) (err error) {
+	f, ok := files[name]
+	if !ok {
+		return errors.New("file not found in archive: " + name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return
+	}
+
+	if err = xml.Unmarshal(b, v); err != nil {
+		return errors.New("cannot parse " + name + ": " + err.Error())
+	}
+
+	return
+}
@@ -0,0 +1,71 @@
+package epub
+
+import (
+	"golang.org/x/text/language"
+)
+
+const (
+	DirectionLeftToRight = "ltr"
+	DirectionRightToLeft = "rtl"
+)
+
+var (
+	languageRightToLeftScripts = map[string]struct{}{
+		"Adlm": {},
+		"Arab": {},
+		"Hebr": {},
+		"Mand": {},
+		"Nkoo": {},
+		"Rohg": {},
+		"Samr": {},
+		"Syrc": {},
+		"Thaa": {},
+	}
+)
+
+func epubInfoOutputInitLanguage(ei *epubInfo) (err error) {
+	if ei.Language == "" {
+		ei.Language = "en"
+	}
+
+	var parseErr error
+
+	// the rest of the book is checked as if it were written in English
+	if ei.output.language, parseErr = language.Parse(ei.Language); parseErr != nil {
+		ei.failOption(`"`+ei.Language+`"`, "invalid language: "+ei.Language)
+		ei.output.language = language.English
+	}
+
+	switch ei.Direction {
+	case DirectionLeftToRight, DirectionRightToLeft:
+		ei.output.direction = ei.Direction
+	default:
+		if ei.Direction != "" {
+			ei.failOption(`"`+ei.Direction+`"`, `direction must be "ltr" or "rtl": `+ei.Direction)
+		}
+
+		ei.output.direction = languageScriptDirection(ei.output.language)
+	}
+
+	return
+}
+
+// languageDirection returns the direction in which text of the given
+// language is written, preferring the book's direction for its own language.
+func (ei *epubInfo) languageDirection(tag language.Tag) string {
+	if tag == ei.output.language {
+		return ei.output.direction
+	}
+
+	return languageScriptDirection(tag)
+}
+
+func languageScriptDirection(tag language.Tag) string {
+	script, _ := tag.Script()
+
+	if _, ok := languageRightToLeftScripts[script.String()]; ok {
+		return DirectionRightToLeft
+	}
+
+	return DirectionLeftToRight
+}
@@ -0,0 +1,179 @@
+package epub
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Contributor is a person who took part in creating a book.
+// Role is a MARC relator code, such as "aut", "edt", "trl" or "ill",
+// and FileAs is the form of the name used for sorting.
+type Contributor struct {
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	FileAs string `json:"file_as"`
+}
+
+// Series describes the collection that a book belongs to
+// and the book's position within it.
+type Series struct {
+	Name  string  `json:"name"`
+	Index float64 `json:"index"`
+}
+
+const (
+	ContributorRoleAuthor      = "aut"
+	ContributorRoleEditor      = "edt"
+	ContributorRoleTranslator  = "trl"
+	ContributorRoleIllustrator = "ill"
+)
+
+var (
+	metadataDateLayouts = []string{
+		"2006",
+		"2006-01",
+		"2006-01-02",
+		time.RFC3339,
+	}
+)
+
+func epubInfoOutputInitMetadata(ei *epubInfo) (err error) {
+	if ei.PublicationDate != "" {
+		if _, ok := parsePublicationDate(ei.PublicationDate); !ok {
+			ei.failOption(`"`+ei.PublicationDate+`"`, "publication date must be formatted as YYYY, YYYY-MM, YYYY-MM-DD or an RFC 3339 timestamp: "+ei.PublicationDate)
+		}
+	}
+
+	for _, contributor := range ei.Contributors {
+		if contributor.Name == "" {
+			ei.failOption(`"contributors"`, "contributor name cannot be empty")
+		}
+
+		if contributor.Role != "" && !metadataIsRelatorCode(contributor.Role) {
+			ei.failOption(`"`+contributor.Role+`"`, "contributor role must be a MARC relator code: "+contributor.Role)
+		}
+	}
+
+	if ei.Series.Name == "" && ei.Series.Index != 0 {
+		ei.failOption(`"series"`, "series index given without a series name")
+	}
+
+	return
+}
+
+// parsePublicationDate parses a date in any of the formats accepted for
+// the publication date of a book.
+func parsePublicationDate(s string) (date time.Time, ok bool) {
+	for _, layout := range metadataDateLayouts {
+		if date, err := time.Parse(layout, s); err == nil {
+			return date, true
+		}
+	}
+
+	return
+}
+
+func metadataIsRelatorCode(role string) bool {
+	if len(role) != 3 {
+		return false
+	}
+
+	for _, r := range role {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (ei *epubInfo) contributors() (contributors []Contributor) {
+	if ei.Author != "" {
+		contributors = append(contributors, Contributor{
+			Name:   ei.Author,
+			Role:   ContributorRoleAuthor,
+			FileAs: ei.AuthorFileAs,
+		})
+	}
+
+	for _, contributor := range ei.Contributors {
+		if contributor.Role == "" {
+			contributor.Role = ContributorRoleAuthor
+		}
+
+		contributors = append(contributors, contributor)
+	}
+
+	return
+}
+
+func generateOCFMetadata(ei *epubInfo, builder *strings.Builder) {
+	for i, contributor := range ei.contributors() {
+		element := "dc:contributor"
+
+		if contributor.Role == ContributorRoleAuthor {
+			element = "dc:creator"
+		}
+
+		if ei.isEPUB3() {
+			id := "contributor_" + strconv.Itoa(i+1)
+
+			builder.WriteString(`<` + element + ` id="` + id + `">` + xmlEscape(contributor.Name) + `</` + element + `>`)
+			builder.WriteString(`<meta refines="#` + id + `" property="role" scheme="marc:relators">` + contributor.Role + `</meta>`)
+
+			if contributor.FileAs != "" {
+				builder.WriteString(`<meta refines="#` + id + `" property="file-as">` + xmlEscape(contributor.FileAs) + `</meta>`)
+			}
+		} else {
+			builder.WriteString(`<` + element + ` opf:role="` + contributor.Role + `"`)
+
+			if contributor.FileAs != "" {
+				builder.WriteString(` opf:file-as="` + xmlEscape(contributor.FileAs) + `"`)
+			}
+
+			builder.WriteString(`>` + xmlEscape(contributor.Name) + `</` + element + `>`)
+		}
+	}
+
+	if ei.Publisher != "" {
+		builder.WriteString(`<dc:publisher>` + xmlEscape(ei.Publisher) + `</dc:publisher>`)
+	}
+
+	if ei.PublicationDate != "" {
+		if ei.isEPUB3() {
+			builder.WriteString(`<dc:date>` + xmlEscape(ei.PublicationDate) + `</dc:date>`)
+		} else {
+			builder.WriteString(`<dc:date opf:event="publication">` + xmlEscape(ei.PublicationDate) + `</dc:date>`)
+		}
+	}
+
+	if ei.Description != "" {
+		builder.WriteString(`<dc:description>` + xmlEscape(ei.Description) + `</dc:description>`)
+	}
+
+	for _, subject := range ei.Subjects {
+		builder.WriteString(`<dc:subject>` + xmlEscape(subject) + `</dc:subject>`)
+	}
+
+	if ei.Rights != "" {
+		builder.WriteString(`<dc:rights>` + xmlEscape(ei.Rights) + `</dc:rights>`)
+	}
+
+	if ei.Series.Name != "" {
+		if ei.isEPUB3() {
+			builder.WriteString(`<meta property="belongs-to-collection" id="series">` + xmlEscape(ei.Series.Name) + `</meta>`)
+			builder.WriteString(`<meta refines="#series" property="collection-type">series</meta>`)
+
+			if ei.Series.Index != 0 {
+				builder.WriteString(`<meta refines="#series" property="group-position">` + strconv.FormatFloat(ei.Series.Index, 'f', -1, 64) + `</meta>`)
+			}
+		} else {
+			builder.WriteString(`<meta name="calibre:series" content="` + xmlEscape(ei.Series.Name) + `" />`)
+
+			if ei.Series.Index != 0 {
+				builder.WriteString(`<meta name="calibre:series_index" content="` + strconv.FormatFloat(ei.Series.Index, 'f', -1, 64) + `" />`)
+			}
+		}
+	}
+}
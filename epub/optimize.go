@@ -0,0 +1,277 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ImageOptions controls the optimisation of images embedded in the book.
+// Images larger than MaxDimension in either direction are scaled down,
+// JPEG images are turned the right way up according to their EXIF
+// orientation and recompressed at JPEGQuality, from 1 to 100, and PNG
+// images are recompressed losslessly. Optimised images are cached by content hash,
+// by the Book for as long as they are used and, when CacheDirectory is set,
+// on disk.
+type ImageOptions struct {
+	ShouldOptimize bool   `json:"should_optimize"`
+	MaxDimension   int    `json:"max_dimension"`
+	JPEGQuality    int    `json:"jpeg_quality"`
+	CacheDirectory string `json:"cache_directory"`
+}
+
+// ImageOptimization reports the result of optimising a single image.
+type ImageOptimization struct {
+	Path          string `json:"path"`
+	OriginalSize  int    `json:"original_size"`
+	OptimizedSize int    `json:"optimized_size"`
+	Cached        bool   `json:"cached"`
+}
+
+const (
+	optimizeDefaultJPEGQuality = 85
+)
+
+func epubInfoOutputInitFileOptimization(ei *epubInfo) (err error) {
+	if !validJPEGQuality(ei.Images.JPEGQuality) {
+		ei.failOption(`"jpeg_quality"`, "JPEG quality must be between 1 and 100: "+strconv.Itoa(ei.Images.JPEGQuality))
+		return
+	}
+
+	if !ei.Images.ShouldOptimize {
+		return
+	}
+
+	ei.Images.CacheDirectory = ei.resolvePath(ei.Images.CacheDirectory)
+
+	if ei.Images.JPEGQuality == 0 {
+		ei.Images.JPEGQuality = optimizeDefaultJPEGQuality
+	}
+
+	for _, datum := range ei.output.fileData {
+		if datum.mimeType != "image/jpeg" && datum.mimeType != "image/png" {
+			continue
+		}
+
+		// an image that cannot be optimised is embedded as it is
+		optimization, optimizeErr := ei.optimizeFileDatum(datum)
+		if optimizeErr != nil {
+			ei.warn(datum.sourcePath, 0, "cannot optimize image, so it is embedded unchanged: "+errorMessage(optimizeErr))
+			continue
+		}
+
+		ei.output.imageOptimizations = append(ei.output.imageOptimizations, optimization)
+	}
+
+	return
+}
+
+func (ei *epubInfo) optimizeFileDatum(datum *epubInfoOutputFileDatum) (optimization ImageOptimization, err error) {
+	optimization = ImageOptimization{
+		Path:         datum.sourcePath,
+		OriginalSize: len(datum.content),
+	}
+
+	orientation := 1
+
+	if datum.mimeType == "image/jpeg" {
+		orientation = jpegOrientation(datum.content)
+	}
+
+	key := "image_" + datum.hash + "_" + strconv.Itoa(ei.Images.MaxDimension) + "_" + strconv.Itoa(ei.Images.JPEGQuality) + "_" + strconv.Itoa(orientation) + datum.ext
+
+	if b, ok := ei.optimizeCacheLoad(key); ok {
+		datum.content = b
+		optimization.OptimizedSize = len(b)
+		optimization.Cached = true
+
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(datum.content))
+	if err != nil {
+		return
+	}
+
+	// re-encoding drops the EXIF orientation of a JPEG image, so the image
+	// is turned the right way up first
+	img = orientImage(img, orientation)
+
+	bounds := img.Bounds()
+	img = shrinkImage(img, ei.Images.MaxDimension, ei.Images.MaxDimension)
+	resized := img.Bounds() != bounds
+
+	var buffer bytes.Buffer
+
+	if datum.mimeType == "image/jpeg" {
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: ei.Images.JPEGQuality})
+	} else {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buffer, img)
+	}
+	if err != nil {
+		return
+	}
+
+	// re-encoding strips metadata, but is only worth keeping when it
+	// does not make an image that was not resized any larger
+	if resized || buffer.Len() < len(datum.content) {
+		datum.content = buffer.Bytes()
+	}
+
+	optimization.OptimizedSize = len(datum.content)
+
+	if cacheErr := ei.optimizeCacheStore(key, datum.content); cacheErr != nil {
+		ei.warn(ei.Images.CacheDirectory, 0, "cannot cache optimized image: "+errorMessage(cacheErr))
+	}
+
+	return
+}
+
+// validJPEGQuality reports whether quality is between 1 and 100,
+// or zero for the default.
+func validJPEGQuality(quality int) bool {
+	return quality >= 0 && quality <= 100
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, from 1,
+// meaning that it is stored the right way up, to 8.
+func jpegOrientation(b []byte) int {
+	const orientationTag = 0x0112
+
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		marker := b[i+1]
+		length := int(binary.BigEndian.Uint16(b[i+2:]))
+
+		// the image data follows the start of scan segment
+		if marker == 0xDA || length < 2 || i+2+length > len(b) {
+			break
+		}
+
+		segment := b[i+4 : i+2+length]
+		i += 2 + length
+
+		if marker != 0xE1 || len(segment) < 14 || string(segment[:6]) != "Exif\x00\x00" {
+			continue
+		}
+
+		tiff := segment[6:]
+
+		var order binary.ByteOrder
+
+		switch string(tiff[:2]) {
+		case "II":
+			order = binary.LittleEndian
+		case "MM":
+			order = binary.BigEndian
+		default:
+			return 1
+		}
+
+		offset := int(order.Uint32(tiff[4:]))
+		if offset < 8 || offset+2 > len(tiff) {
+			return 1
+		}
+
+		count := int(order.Uint16(tiff[offset:]))
+
+		for entry := offset + 2; entry+12 <= len(tiff) && count > 0; entry, count = entry+12, count-1 {
+			if order.Uint16(tiff[entry:]) == orientationTag {
+				if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+					return orientation
+				}
+
+				return 1
+			}
+		}
+
+		return 1
+	}
+
+	return 1
+}
+
+// orientImage turns an image with the given EXIF orientation
+// the right way up.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// orientations 5 to 8 turn the image on its side
+	dstWidth, dstHeight := width, height
+
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dstX, dstY int
+
+			switch orientation {
+			case 2: // mirrored horizontally
+				dstX, dstY = width-1-x, y
+			case 3: // rotated 180 degrees
+				dstX, dstY = width-1-x, height-1-y
+			case 4: // mirrored vertically
+				dstX, dstY = x, height-1-y
+			case 5: // mirrored along the top-left to bottom-right diagonal
+				dstX, dstY = y, x
+			case 6: // rotated 90 degrees anticlockwise
+				dstX, dstY = height-1-y, x
+			case 7: // mirrored along the top-right to bottom-left diagonal
+				dstX, dstY = height-1-y, width-1-x
+			case 8: // rotated 90 degrees clockwise
+				dstX, dstY = y, width-1-x
+			}
+
+			dst.Set(dstX, dstY, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	return dst
+}
+
+func (ei *epubInfo) optimizeCacheLoad(key string) (b []byte, ok bool) {
+	if b, ok = ei.cache.load(key); ok || ei.Images.CacheDirectory == "" {
+		return
+	}
+
+	b, err := os.ReadFile(filepath.Join(ei.Images.CacheDirectory, key))
+	if err != nil {
+		return nil, false
+	}
+
+	ei.cache.store(key, b)
+
+	return b, true
+}
+
+func (ei *epubInfo) optimizeCacheStore(key string, b []byte) (err error) {
+	ei.cache.store(key, b)
+
+	if ei.Images.CacheDirectory == "" {
+		return
+	}
+
+	if err = os.MkdirAll(ei.Images.CacheDirectory, 0o755); err != nil {
+		return
+	}
+
+	return os.WriteFile(filepath.Join(ei.Images.CacheDirectory, key), b, 0o644)
+}
@@ -0,0 +1,202 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := testJPEG(t, 4, 2)
+
+	tests := []struct {
+		name string
+		b    []byte
+		want int
+	}{
+		{"no exif", plain, 1},
+		{"little endian", withTestOrientation(plain, binary.LittleEndian, 6), 6},
+		{"big endian", withTestOrientation(plain, binary.BigEndian, 8), 8},
+		{"out of range", withTestOrientation(plain, binary.BigEndian, 9), 1},
+		{"truncated", withTestOrientation(plain, binary.BigEndian, 3)[:30], 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+	}
+
+	for _, test := range tests {
+		if got := jpegOrientation(test.b); got != test.want {
+			t.Errorf("%s: got orientation %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// a 3x2 image whose pixels can be told apart
+	src := image.NewGray(image.Rect(0, 0, 3, 2))
+
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{1, [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{2, [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{3, [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{4, [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{5, [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+		{6, [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{7, [][]uint8{{5, 2}, {4, 1}, {3, 0}}},
+		{8, [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+	}
+
+	for _, test := range tests {
+		dst := orientImage(src, test.orientation)
+
+		var got [][]uint8
+
+		for y := dst.Bounds().Min.Y; y < dst.Bounds().Max.Y; y++ {
+			var row []uint8
+
+			for x := dst.Bounds().Min.X; x < dst.Bounds().Max.X; x++ {
+				row = append(row, color.GrayModel.Convert(dst.At(x, y)).(color.Gray).Y)
+			}
+
+			got = append(got, row)
+		}
+
+		if !equalTestRows(got, test.want) {
+			t.Errorf("orientation %d: got %v, want %v", test.orientation, got, test.want)
+		}
+	}
+}
+
+func TestOptimizeRotatedJPEG(t *testing.T) {
+	b := withTestOrientation(testJPEG(t, 40, 20), binary.LittleEndian, 6)
+
+	options := Options{Title: "Photos"}
+
+	options.Paths.Text = "text.md"
+	options.Images.ShouldOptimize = true
+
+	r := buildTestBook(t, map[string]string{
+		"text.md":   "# Photo\n\n![photo](photo.jpg)\n",
+		"photo.jpg": string(b),
+	}, options)
+
+	var found bool
+
+	for _, f := range r.File {
+		if !strings.HasSuffix(f.Name, ".jpg") {
+			continue
+		}
+
+		config, err := jpeg.DecodeConfig(strings.NewReader(readTestArchiveFile(t, r, f.Name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if config.Width != 20 || config.Height != 40 {
+			t.Errorf("%s is %dx%d, want 20x40", f.Name, config.Width, config.Height)
+		}
+
+		found = true
+	}
+
+	if !found {
+		t.Fatal("the photo is missing from the archive")
+	}
+
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "text.md"), []byte("# Text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	options.BaseDirectory = dir
+
+	for _, quality := range []int{-1, 101} {
+		options.Images.JPEGQuality = quality
+
+		book := NewBook(options)
+
+		if err := book.Build(context.Background(), io.Discard); err == nil {
+			t.Errorf("JPEG quality %d: want an error, got none", quality)
+		} else if !strings.Contains(book.Diagnostics().Errors().Error(), "JPEG quality must be between 1 and 100") {
+			t.Errorf("JPEG quality %d: unexpected errors %v", quality, book.Diagnostics().Errors())
+		}
+	}
+}
+
+func testJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	// noise at the highest quality leaves re-encoding something to save
+	img := image.NewGray(image.Rect(0, 0, width, height))
+
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7919)
+	}
+
+	var buffer bytes.Buffer
+
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+// withTestOrientation returns a copy of a JPEG image with an EXIF segment,
+// written in the given byte order, giving its orientation.
+func withTestOrientation(b []byte, order binary.ByteOrder, orientation int) []byte {
+	tiff := make([]byte, 8+2+12+4)
+
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+
+	result := append([]byte{}, b[:2]...)
+	result = append(result, header...)
+	result = append(result, segment...)
+
+	return append(result, b[2:]...)
+}
+
+func equalTestRows(a, b [][]uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,160 @@
+package epub
+
+import (
+	"bytes"
+	"embed"
+	"encoding/xml"
+	"html/template"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	//go:embed templates/*.html
+	templateFS embed.FS
+
+	templateFuncs = template.FuncMap{
+		"ordinal": humanize.Ordinal,
+	}
+)
+
+type templateData struct {
+	Options
+	Year     int
+	TextPath string
+	Headings []*templateHeading
+}
+
+type templateHeading struct {
+	Text     string
+	Href     string
+	Level    int
+	Children []*templateHeading
+}
+
+func newTemplateHeadings(headings []*epubInfoOutputTextHeading) (templateHeadings []*templateHeading) {
+	for _, heading := range headings {
+		templateHeadings = append(templateHeadings, &templateHeading{
+			Text:     heading.text,
+			Href:     heading.href(),
+			Level:    heading.level,
+			Children: newTemplateHeadings(heading.children),
+		})
+	}
+
+	return
+}
+
+func epubInfoOutputInitTemplates(ei *epubInfo) (err error) {
+	for _, path := range []string{ei.Paths.TitleTemplate, ei.Paths.CopyrightTemplate, ei.Paths.ContentsTemplate} {
+		if path != "" {
+			ei.addSource(ei.resolvePath(path))
+		}
+	}
+
+	if ei.output.titleTemplate, err = parseTemplate("title", ei.resolvePath(ei.Paths.TitleTemplate)); err != nil {
+		return
+	}
+
+	if ei.output.copyrightTemplate, err = parseTemplate("copyright", ei.resolvePath(ei.Paths.CopyrightTemplate)); err != nil {
+		return
+	}
+
+	if ei.output.contentsTemplate, err = parseTemplate("contents", ei.resolvePath(ei.Paths.ContentsTemplate)); err != nil {
+		return
+	}
+
+	overrides := []struct {
+		path     string
+		t        *template.Template
+		included bool
+	}{
+		{ei.Paths.TitleTemplate, ei.output.titleTemplate, true},
+		{ei.Paths.CopyrightTemplate, ei.output.copyrightTemplate, ei.IncludeCopyrightPage},
+		{ei.Paths.ContentsTemplate, ei.output.contentsTemplate, ei.IncludeContentsPage},
+	}
+
+	for _, override := range overrides {
+		if override.path == "" || !override.included {
+			continue
+		}
+
+		// html/template escapes values for HTML, so nothing stops an
+		// override from writing markup, such as <br> or &nbsp;, that is
+		// not valid in an XHTML document
+		var b []byte
+
+		if b, err = ei.executeTemplate(override.t); err != nil {
+			return
+		}
+
+		if xmlErr := validateXMLFragment(b); xmlErr != nil {
+			ei.fail(ei.resolvePath(override.path), 0, "template output is not well-formed XML", xmlErr)
+		}
+	}
+
+	return
+}
+
+// validateXMLFragment returns the first error found when reading b, the
+// content of a body element, as XML.
+func validateXMLFragment(b []byte) (err error) {
+	decoder := xml.NewDecoder(io.MultiReader(
+		bytes.NewReader([]byte("<body>")),
+		bytes.NewReader(b),
+		bytes.NewReader([]byte("</body>")),
+	))
+	decoder.Strict = true
+
+	for {
+		if _, err = decoder.Token(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+
+			return
+		}
+	}
+}
+
+func parseTemplate(name, path string) (t *template.Template, err error) {
+	var b []byte
+
+	if path == "" {
+		b, err = templateFS.ReadFile("templates/" + name + ".html")
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return
+	}
+
+	return template.New(name).Funcs(templateFuncs).Parse(string(b))
+}
+
+func (ei *epubInfo) executeTemplate(t *template.Template) (b []byte, err error) {
+	data := templateData{
+		Options:  ei.Options,
+		Year:     time.Now().UTC().Year(),
+		TextPath: ei.output.textChapters[0].path,
+		Headings: newTemplateHeadings(ei.output.textHeadingsTree),
+	}
+
+	// a book is copyrighted in the year that it was published
+	if date, ok := parsePublicationDate(ei.PublicationDate); ok {
+		data.Year = date.Year()
+	}
+
+	var buffer bytes.Buffer
+
+	if err = t.Execute(&buffer, data); err != nil {
+		return
+	}
+
+	b = xmlSanitize(buffer.Bytes())
+
+	return
+}
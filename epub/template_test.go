@@ -0,0 +1,76 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateOverrideNotWellFormed(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		fail     bool
+	}{
+		{"void element", `<h1>{{.Title}}</h1><br>`, true},
+		{"html entity", `<h1>{{.Title}}&nbsp;</h1>`, true},
+		{"unclosed element", `<div><h1>{{.Title}}</h1>`, true},
+		{"well-formed", `<h1>{{.Title}}</h1><br/><p>&#160;&amp;</p>`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			for name, content := range map[string]string{
+				"text.md":    "# One\n\nText.\n",
+				"title.html": test.template,
+			} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			options := Options{
+				Title:         "Templates & Things",
+				BaseDirectory: dir,
+			}
+
+			options.Paths.Text = "text.md"
+			options.Paths.TitleTemplate = "title.html"
+
+			book := NewBook(options)
+
+			err := book.Build(context.Background(), &bytes.Buffer{})
+
+			if !test.fail {
+				if err != nil {
+					t.Fatalf("%v: %v", err, book.Diagnostics())
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatal("want an error, got none")
+			}
+
+			failures := book.Diagnostics().Errors()
+
+			if len(failures) != 1 {
+				t.Fatalf("want one error, got %v", failures)
+			}
+
+			if failures[0].Path != filepath.Join(dir, "title.html") {
+				t.Errorf("error points at %q, want the template", failures[0].Path)
+			}
+
+			if !strings.Contains(failures[0].Message, "not well-formed") {
+				t.Errorf("unexpected error message %q", failures[0].Message)
+			}
+		})
+	}
+}
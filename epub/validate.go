@@ -0,0 +1,444 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ValidationProblem describes a single rule broken by an EPUB archive.
+type ValidationProblem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (problem ValidationProblem) String() string {
+	if problem.Path == "" {
+		return problem.Message
+	}
+
+	return problem.Path + ": " + problem.Message
+}
+
+type validateContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type validatePackage struct {
+	Manifest struct {
+		Items []struct {
+			ID        string `xml:"id,attr"`
+			Href      string `xml:"href,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"item"`
+	} `xml:"manifest"`
+	Spine struct {
+		TOC      string `xml:"toc,attr"`
+		Itemrefs []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// validateFragment is a reference to an element of a document,
+// which can only be checked once every document has been read.
+type validateFragment struct {
+	docPath    string
+	ref        string
+	targetPath string
+	id         string
+}
+
+type validator struct {
+	files         map[string]*zip.File
+	manifestPaths map[string]struct{}
+	// ids holds the identifiers of the elements of each XML document
+	ids       map[string]map[string]struct{}
+	fragments []validateFragment
+	problems  []ValidationProblem
+}
+
+// Validate opens the EPUB archive found at path and reports
+// every problem found in it. The returned error is only non-nil
+// when the archive cannot be read at all.
+func Validate(path string) (problems []ValidationProblem, err error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	return ValidateReader(&r.Reader)
+}
+
+// ValidateReader reports every problem found in an EPUB archive.
+func ValidateReader(r *zip.Reader) (problems []ValidationProblem, err error) {
+	v := &validator{
+		files:         make(map[string]*zip.File, len(r.File)),
+		manifestPaths: make(map[string]struct{}),
+		ids:           make(map[string]map[string]struct{}),
+	}
+
+	for _, f := range r.File {
+		v.files[f.Name] = f
+	}
+
+	if err = v.validateMimetype(r); err != nil {
+		return
+	}
+
+	opfPath, err := v.validateContainer()
+	if err != nil {
+		return
+	}
+
+	if opfPath != "" {
+		if err = v.validatePackage(opfPath); err != nil {
+			return
+		}
+	}
+
+	problems = v.problems
+
+	return
+}
+
+func (v *validator) report(path, message string) {
+	v.problems = append(v.problems, ValidationProblem{
+		Path:    path,
+		Message: message,
+	})
+}
+
+func (v *validator) readFile(name string) (b []byte, err error) {
+	f, ok := v.files[name]
+	if !ok {
+		err = errors.New("file not found in archive: " + name)
+		return
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+func (v *validator) validateMimetype(r *zip.Reader) (err error) {
+	if len(r.File) == 0 || r.File[0].Name != "mimetype" {
+		v.report("mimetype", "must be the first file in the archive")
+	}
+
+	f, ok := v.files["mimetype"]
+	if !ok {
+		return
+	}
+
+	if f.Method != zip.Store {
+		v.report("mimetype", "must be stored without compression")
+	}
+
+	b, err := v.readFile("mimetype")
+	if err != nil {
+		return
+	}
+
+	if string(b) != "application/epub+zip" {
+		v.report("mimetype", `must contain exactly "application/epub+zip"`)
+	}
+
+	return
+}
+
+func (v *validator) validateContainer() (opfPath string, err error) {
+	const containerPath = "META-INF/container.xml"
+
+	if _, ok := v.files[containerPath]; !ok {
+		v.report(containerPath, "is missing")
+		return
+	}
+
+	b, err := v.readFile(containerPath)
+	if err != nil {
+		return
+	}
+
+	var container validateContainer
+
+	if xmlErr := xml.Unmarshal(b, &container); xmlErr != nil {
+		v.report(containerPath, "is not well-formed: "+xmlErr.Error())
+		return
+	}
+
+	for _, rootfile := range container.Rootfiles {
+		if rootfile.MediaType == "application/oebps-package+xml" {
+			opfPath = rootfile.FullPath
+			break
+		}
+	}
+
+	if opfPath == "" {
+		v.report(containerPath, "does not declare a package document")
+		return
+	}
+
+	if _, ok := v.files[opfPath]; !ok {
+		v.report(containerPath, "points at missing package document "+opfPath)
+		opfPath = ""
+	}
+
+	return
+}
+
+func (v *validator) validatePackage(opfPath string) (err error) {
+	b, err := v.readFile(opfPath)
+	if err != nil {
+		return
+	}
+
+	var pkg validatePackage
+
+	if xmlErr := xml.Unmarshal(b, &pkg); xmlErr != nil {
+		v.report(opfPath, "is not well-formed: "+xmlErr.Error())
+		return
+	}
+
+	opfDir := path.Dir(opfPath)
+	manifestIDs := make(map[string]struct{}, len(pkg.Manifest.Items))
+
+	for _, item := range pkg.Manifest.Items {
+		manifestIDs[item.ID] = struct{}{}
+
+		itemPath, ok := validateResolve(opfDir, item.Href)
+		if !ok {
+			v.report(opfPath, "manifest item "+item.ID+" has an invalid href "+item.Href)
+			continue
+		}
+
+		v.manifestPaths[itemPath] = struct{}{}
+
+		if _, ok := v.files[itemPath]; !ok {
+			v.report(opfPath, "manifest item "+item.ID+" refers to missing file "+itemPath)
+		}
+	}
+
+	v.validateUndeclaredFiles(opfPath)
+
+	if pkg.Spine.TOC != "" {
+		if _, ok := manifestIDs[pkg.Spine.TOC]; !ok {
+			v.report(opfPath, "spine toc "+pkg.Spine.TOC+" is not declared in the manifest")
+		}
+	}
+
+	if len(pkg.Spine.Itemrefs) == 0 {
+		v.report(opfPath, "spine is empty")
+	}
+
+	for _, itemref := range pkg.Spine.Itemrefs {
+		if _, ok := manifestIDs[itemref.IDRef]; !ok {
+			v.report(opfPath, "spine itemref "+itemref.IDRef+" is not declared in the manifest")
+		}
+	}
+
+	for _, item := range pkg.Manifest.Items {
+		itemPath, ok := validateResolve(opfDir, item.Href)
+		if !ok {
+			continue
+		}
+
+		if _, ok := v.files[itemPath]; !ok {
+			continue
+		}
+
+		switch item.MediaType {
+		case "application/xhtml+xml", "application/x-dtbncx+xml", "image/svg+xml":
+			err = v.validateXMLDocument(itemPath)
+		case "text/css":
+			err = v.validateStylesheet(itemPath)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	v.validateFragments()
+
+	return
+}
+
+// validateUndeclaredFiles reports every file in the archive that is not
+// declared in the manifest, other than those of the container itself.
+func (v *validator) validateUndeclaredFiles(opfPath string) {
+	var names []string
+
+	for name := range v.files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "mimetype" || name == opfPath || strings.HasPrefix(name, "META-INF/") || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		if _, ok := v.manifestPaths[name]; !ok {
+			v.report(name, "is not declared in the manifest")
+		}
+	}
+}
+
+func (v *validator) validateXMLDocument(docPath string) (err error) {
+	b, err := v.readFile(docPath)
+	if err != nil {
+		return
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	decoder.Strict = true
+
+	ids := make(map[string]struct{})
+	v.ids[docPath] = ids
+
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr == io.EOF {
+			break
+		}
+		if tokenErr != nil {
+			v.report(docPath, "is not well-formed: "+tokenErr.Error())
+
+			// the ids of a document that cannot be read in full are unknown
+			delete(v.ids, docPath)
+
+			break
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		for _, attr := range element.Attr {
+			switch attr.Name.Local {
+			case "id":
+				ids[attr.Value] = struct{}{}
+			case "href", "src":
+				v.validateReference(docPath, attr.Value)
+			}
+		}
+	}
+
+	return
+}
+
+var (
+	validateStylesheetUrlRegexp = regexp.MustCompile(`url\(\s*["']?([^"')]*)["']?\s*\)`)
+)
+
+func (v *validator) validateStylesheet(stylesheetPath string) (err error) {
+	b, err := v.readFile(stylesheetPath)
+	if err != nil {
+		return
+	}
+
+	for _, submatches := range validateStylesheetUrlRegexp.FindAllSubmatch(b, -1) {
+		v.validateReference(stylesheetPath, string(submatches[1]))
+	}
+
+	return
+}
+
+func (v *validator) validateReference(docPath, ref string) {
+	if ref == "" {
+		return
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		v.report(docPath, "has an invalid reference "+ref)
+		return
+	}
+
+	if u.Scheme != "" || u.Host != "" {
+		return
+	}
+
+	if u.Path == "" {
+		v.addFragment(docPath, ref, docPath, u.Fragment)
+		return
+	}
+
+	refPath, ok := validateResolve(path.Dir(docPath), u.EscapedPath())
+	if !ok {
+		v.report(docPath, "has an invalid reference "+ref)
+		return
+	}
+
+	if _, ok := v.files[refPath]; !ok {
+		v.report(docPath, "refers to missing file "+refPath)
+		return
+	}
+
+	if _, ok := v.manifestPaths[refPath]; !ok {
+		v.report(docPath, "refers to file "+refPath+" that is not declared in the manifest")
+	}
+
+	v.addFragment(docPath, ref, refPath, u.Fragment)
+}
+
+func (v *validator) addFragment(docPath, ref, targetPath, id string) {
+	if id == "" {
+		return
+	}
+
+	v.fragments = append(v.fragments, validateFragment{
+		docPath:    docPath,
+		ref:        ref,
+		targetPath: targetPath,
+		id:         id,
+	})
+}
+
+// validateFragments reports the references to elements that are not found
+// in the documents that they refer to. Fragments of other kinds of file,
+// such as images, are not checked.
+func (v *validator) validateFragments() {
+	for _, fragment := range v.fragments {
+		ids, ok := v.ids[fragment.targetPath]
+		if !ok {
+			continue
+		}
+
+		if _, ok := ids[fragment.id]; !ok {
+			v.report(fragment.docPath, "refers to missing fragment "+fragment.ref)
+		}
+	}
+}
+
+func validateResolve(dir, href string) (resolved string, ok bool) {
+	href, err := url.PathUnescape(href)
+	if err != nil || href == "" {
+		return
+	}
+
+	resolved = path.Join(dir, href)
+
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", false
+	}
+
+	return resolved, true
+}
@@ -0,0 +1,173 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type testArchiveFile struct {
+	name    string
+	content string
+}
+
+var testArchiveFiles = []testArchiveFile{
+	{"mimetype", "application/epub+zip"},
+	{"META-INF/container.xml", `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+	{"content.opf", `<?xml version="1.0"?><package xmlns="http://www.idpf.org/2007/opf" version="3.0"><manifest>` +
+		`<item id="chapter_1" href="chapter_1.xhtml" media-type="application/xhtml+xml"/>` +
+		`<item id="chapter_2" href="chapter_2.xhtml" media-type="application/xhtml+xml"/>` +
+		`<item id="styles" href="styles.css" media-type="text/css"/>` +
+		`</manifest><spine><itemref idref="chapter_1"/><itemref idref="chapter_2"/></spine></package>`},
+	{"chapter_1.xhtml", `<html xmlns="http://www.w3.org/1999/xhtml"><head><link rel="stylesheet" href="styles.css"/></head><body><h1 id="start">One</h1><a href="chapter_2.xhtml#end">next</a></body></html>`},
+	{"chapter_2.xhtml", `<html xmlns="http://www.w3.org/1999/xhtml"><body><p id="end">Two</p><a href="#end">again</a><a href="https://example.com/#anywhere">away</a></body></html>`},
+	{"styles.css", `body { margin: 0; }`},
+}
+
+func TestValidateReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		replace  map[string]string
+		first    string
+		problems []string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name:     "mimetype not first",
+			first:    "content.opf",
+			problems: []string{"mimetype: must be the first file in the archive"},
+		},
+		{
+			name:     "wrong mimetype",
+			replace:  map[string]string{"mimetype": "application/zip"},
+			problems: []string{`mimetype: must contain exactly "application/epub+zip"`},
+		},
+		{
+			name:     "malformed page",
+			replace:  map[string]string{"chapter_2.xhtml": `<html><body><p>unclosed</body></html>`},
+			problems: []string{"chapter_2.xhtml: is not well-formed"},
+		},
+		{
+			name:     "missing file",
+			replace:  map[string]string{"chapter_1.xhtml": `<html><body><img src="missing.png"/></body></html>`},
+			problems: []string{"chapter_1.xhtml: refers to missing file missing.png"},
+		},
+		{
+			name:     "undeclared file",
+			replace:  map[string]string{"chapter_1.xhtml": `<html><body><a href="mimetype">x</a></body></html>`},
+			problems: []string{"chapter_1.xhtml: refers to file mimetype that is not declared in the manifest"},
+		},
+		{
+			name:     "missing fragment",
+			replace:  map[string]string{"chapter_1.xhtml": `<html><body><a href="chapter_2.xhtml#nope">x</a></body></html>`},
+			problems: []string{"chapter_1.xhtml: refers to missing fragment chapter_2.xhtml#nope"},
+		},
+		{
+			name:     "missing fragment in the same page",
+			replace:  map[string]string{"chapter_2.xhtml": `<html><body><p id="end">Two</p><a href="#nope">x</a></body></html>`},
+			problems: []string{"chapter_2.xhtml: refers to missing fragment #nope"},
+		},
+		{
+			name: "undeclared spine item",
+			replace: map[string]string{"content.opf": `<package><manifest><item id="chapter_1" href="chapter_1.xhtml" media-type="application/xhtml+xml"/>` +
+				`<item id="chapter_2" href="chapter_2.xhtml" media-type="application/xhtml+xml"/></manifest>` +
+				`<spine><itemref idref="chapter_1"/><itemref idref="chapter_3"/></spine></package>`},
+			problems: []string{
+				"styles.css: is not declared in the manifest",
+				"content.opf: spine itemref chapter_3 is not declared in the manifest",
+				"chapter_1.xhtml: refers to file styles.css that is not declared in the manifest",
+			},
+		},
+		{
+			name: "file missing from manifest",
+			replace: map[string]string{
+				"content.opf": `<package><manifest><item id="chapter_1" href="chapter_1.xhtml" media-type="application/xhtml+xml"/>` +
+					`<item id="chapter_2" href="chapter_2.xhtml" media-type="application/xhtml+xml"/></manifest>` +
+					`<spine><itemref idref="chapter_1"/><itemref idref="chapter_2"/></spine></package>`,
+				"chapter_1.xhtml": `<html><body><h1>One</h1></body></html>`,
+			},
+			problems: []string{"styles.css: is not declared in the manifest"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestArchive(t, test.replace, test.first)
+
+			problems, err := ValidateReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var messages []string
+
+			for _, problem := range problems {
+				messages = append(messages, problem.String())
+			}
+
+			if len(messages) != len(test.problems) {
+				t.Fatalf("got problems %q, want %q", messages, test.problems)
+			}
+
+			for i, message := range messages {
+				if !strings.HasPrefix(message, test.problems[i]) {
+					t.Errorf("got problem %q, want %q", message, test.problems[i])
+				}
+			}
+		})
+	}
+}
+
+// newTestArchive builds an archive of testArchiveFiles, with the content of
+// some files replaced and, optionally, a file other than mimetype first.
+func newTestArchive(t *testing.T, replace map[string]string, first string) *zip.Reader {
+	files := append([]testArchiveFile{}, testArchiveFiles...)
+
+	for i, file := range files {
+		if content, ok := replace[file.name]; ok {
+			files[i].content = content
+		}
+
+		if file.name == first {
+			files[0], files[i] = files[i], files[0]
+		}
+	}
+
+	var buffer bytes.Buffer
+
+	w := zip.NewWriter(&buffer)
+
+	for _, file := range files {
+		header := &zip.FileHeader{
+			Name:   file.name,
+			Method: zip.Deflate,
+		}
+
+		if file.name == "mimetype" {
+			header.Method = zip.Store
+		}
+
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = fw.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
@@ -0,0 +1,75 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+)
+
+func (ei *epubInfo) xhtmlHeader(lang, direction, title string, stylesheetPaths []string, headContent string) string {
+	var builder strings.Builder
+
+	builder.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	builder.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml"`)
+
+	// the epub namespace and the lang attribute are not part of XHTML 1.1,
+	// which EPUB 2 uses
+	if ei.isEPUB3() {
+		builder.WriteString(` xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` + xmlEscape(lang) + `" lang="` + xmlEscape(lang) + `"`)
+	} else {
+		builder.WriteString(` xml:lang="` + xmlEscape(lang) + `"`)
+	}
+
+	if direction == DirectionRightToLeft {
+		builder.WriteString(` dir="rtl"`)
+	}
+
+	builder.WriteString(`>`)
+	builder.WriteString(`<head>`)
+	builder.WriteString(`<title>` + xmlEscape(title) + `</title>`)
+
+	for _, path := range stylesheetPaths {
+		builder.WriteString(`<link rel="stylesheet" href="` + xmlEscape(path) + `" type="text/css" />`)
+	}
+
+	builder.WriteString(`<style type="text/css">h1{page-break-before: always;}</style>`)
+
+	if headContent != "" {
+		builder.WriteString(headContent)
+	}
+
+	builder.WriteString(`</head>`)
+	builder.WriteString(`<body>`)
+
+	return builder.String()
+}
+
+func xhtmlFooter() string {
+	return "</body></html>"
+}
+
+// xmlEscape makes arbitrary text safe to embed within XML character data
+// or a quoted attribute value.
+func xmlEscape(s string) string {
+	var builder strings.Builder
+
+	// writing to a strings.Builder cannot fail
+	xml.EscapeText(&builder, []byte(s))
+
+	return builder.String()
+}
+
+// xmlSanitize replaces every character that may not appear
+// in an XML document with the Unicode replacement character.
+func xmlSanitize(b []byte) []byte {
+	return bytes.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' ||
+			r >= 0x20 && r <= 0xD7FF ||
+			r >= 0xE000 && r <= 0xFFFD ||
+			r >= 0x10000 && r <= 0x10FFFF {
+			return r
+		}
+
+		return '\uFFFD'
+	}, b)
+}
@@ -4,16 +4,17 @@ go 1.20
 
 require (
 	github.com/PuerkitoBio/goquery v1.9.1
+	github.com/andybalholm/cascadia v1.3.2
 	github.com/dustin/go-humanize v1.0.1
 	github.com/gomarkdown/markdown v0.0.0-20231222211730-1d6d20845b47
 	github.com/iancoleman/strcase v0.3.0
 	github.com/tdewolff/minify v2.3.6+incompatible
 	github.com/theTardigrade/golang-hash v1.4.3
-	golang.org/x/text v0.14.0
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.16.0
 )
 
 require (
-	github.com/andybalholm/cascadia v1.3.2 // indirect
 	github.com/tdewolff/parse v2.3.4+incompatible // indirect
 	github.com/tdewolff/test v1.0.10 // indirect
 	golang.org/x/net v0.21.0 // indirect
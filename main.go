@@ -2,27 +2,229 @@ package main
 
 import (
 	"encoding/json"
-	_ "image/png"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/theTardigrade/golang-epubGenerator/epub"
+)
+
+const (
+	exitSuccess = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
 )
 
+const (
+	verbosityQuiet = iota
+	verbosityNormal
+	verbosityVerbose
+)
+
+type command struct {
+	name        string
+	arguments   string
+	description string
+	run         func(args []string) error
+}
+
+// usageError is returned by commands that were invoked incorrectly.
+type usageError struct {
+	message string
+}
+
+func (err usageError) Error() string {
+	return err.message
+}
+
+var (
+	programName = filepath.Base(os.Args[0])
+	commands    []*command
+)
+
+func init() {
+	commands = []*command{
+		buildCommand,
+		validateCommand,
+		inspectCommand,
+		initCommand,
+		watchCommand,
+		serveCommand,
+	}
+}
+
 func main() {
-	fileContent, err := os.ReadFile(epubInfoFileName)
-	if err != nil {
-		panic(err)
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	name := buildCommand.name
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	var ei epubInfo
+	if name == "help" {
+		printUsage(os.Stdout)
+		return exitSuccess
+	}
+
+	var cmd *command
+
+	for _, c := range commands {
+		if c.name == name {
+			cmd = c
+			break
+		}
+	}
 
-	if err = json.Unmarshal(fileContent, &ei); err != nil {
-		panic(err)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", programName, name)
+		printUsage(os.Stderr)
+		return exitUsage
 	}
 
-	if err = epubInfoOutputInit(&ei); err != nil {
-		panic(err)
+	err := cmd.run(args)
+
+	var usageErr usageError
+
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitSuccess
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", programName, cmd.name, err)
+		fmt.Fprintf(os.Stderr, "run '%s %s -help' for usage\n", programName, cmd.name)
+		return exitUsage
+	default:
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", programName, cmd.name, err)
+		return exitFailure
 	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s <command> [flags] [arguments]\n\n", programName)
+	fmt.Fprintln(w, "commands:")
 
-	if err = generate(&ei); err != nil {
-		panic(err)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.description)
 	}
+
+	fmt.Fprintf(w, "\nwithout a command, %s runs build\n", programName)
+}
+
+func newFlagSet(cmd *command) *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s %s [flags]", programName, cmd.name)
+
+		if cmd.arguments != "" {
+			fmt.Fprint(flags.Output(), " "+cmd.arguments)
+		}
+
+		fmt.Fprintf(flags.Output(), "\n\n%s\n\nflags:\n", cmd.description)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// parseFlags parses args, reporting malformed flags as usage errors.
+func parseFlags(flags *flag.FlagSet, args []string) (err error) {
+	if err = flags.Parse(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return usageError{message: err.Error()}
+	}
+
+	return
+}
+
+// reportFlags are the flags that control how a command reports its results.
+type reportFlags struct {
+	format  string
+	verbose bool
+	quiet   bool
+}
+
+func addReportFlags(flags *flag.FlagSet) *reportFlags {
+	report := &reportFlags{}
+
+	flags.StringVar(&report.format, "format", formatText, "report `format`, either text or json")
+	flags.BoolVar(&report.verbose, "v", false, "report in more detail")
+	flags.BoolVar(&report.quiet, "q", false, "report nothing but errors")
+
+	return report
+}
+
+func (report *reportFlags) validate() (err error) {
+	if report.format != formatText && report.format != formatJSON {
+		return usageError{message: "unsupported format: " + report.format}
+	}
+
+	if report.verbose && report.quiet {
+		return usageError{message: "-v and -q cannot be used together"}
+	}
+
+	return
+}
+
+func (report *reportFlags) verbosity() int {
+	switch {
+	case report.quiet:
+		return verbosityQuiet
+	case report.verbose:
+		return verbosityVerbose
+	}
+
+	return verbosityNormal
+}
+
+func printJSON(w io.Writer, v interface{}) (err error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+// setFlag collects the key=value pairs given to repeated -set flags.
+type setFlag []string
+
+func (f *setFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *setFlag) Set(value string) (err error) {
+	if !strings.Contains(value, "=") {
+		return errors.New("expected key=value")
+	}
+
+	*f = append(*f, value)
+
+	return
+}
+
+// loadOptions reads the options file at path and applies the overrides.
+func loadOptions(path string, overrides setFlag) (options epub.Options, err error) {
+	if options, err = epub.LoadOptions(path); err != nil {
+		return
+	}
+
+	for _, override := range overrides {
+		key, value, _ := strings.Cut(override, "=")
+
+		if err = options.Set(key, value); err != nil {
+			return
+		}
+	}
+
+	return
 }
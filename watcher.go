@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	hash "github.com/theTardigrade/golang-hash"
+)
+
+type sourceState struct {
+	exists  bool
+	isDir   bool
+	modTime time.Time
+	size    int64
+	digest  string
+}
+
+// sourceWatcher polls files and directories for changes. A source is only
+// reported as changed when its content differs, so that merely touching
+// a file does not cause a rebuild.
+type sourceWatcher struct {
+	paths  []string
+	states map[string]sourceState
+}
+
+func newSourceWatcher(paths []string) *sourceWatcher {
+	watcher := &sourceWatcher{
+		states: make(map[string]sourceState, len(paths)),
+	}
+
+	watcher.setPaths(paths, time.Time{})
+
+	return watcher
+}
+
+// setPaths changes the sources being watched. Sources that were already
+// being watched keep their last known state, so that changes made to them
+// since then are still reported. Any other file modified since the given
+// time, when it may have been read, is reported as changed by the next
+// check; directories are not, as the book may have been written to one.
+func (watcher *sourceWatcher) setPaths(paths []string, since time.Time) {
+	states := make(map[string]sourceState, len(paths))
+
+	for _, path := range paths {
+		state, ok := watcher.states[path]
+		if !ok {
+			state = statSource(path)
+			state.digest = digestSource(path, state)
+
+			if state.exists && !state.isDir && !since.IsZero() && !state.modTime.Before(since) {
+				state = sourceState{}
+			}
+		}
+
+		states[path] = state
+	}
+
+	watcher.paths = paths
+	watcher.states = states
+}
+
+// changes returns the sources that have changed since they were last
+// checked, in the order in which they were given.
+func (watcher *sourceWatcher) changes() (changed []string) {
+	for _, path := range watcher.paths {
+		previous := watcher.states[path]
+		state := statSource(path)
+
+		if state.exists == previous.exists && state.modTime.Equal(previous.modTime) && state.size == previous.size {
+			continue
+		}
+
+		state.digest = digestSource(path, state)
+		watcher.states[path] = state
+
+		if state.exists != previous.exists || state.digest != previous.digest {
+			changed = append(changed, path)
+		}
+	}
+
+	return
+}
+
+// wait polls the sources every interval until one or more of them change,
+// then waits for them to stop changing, as editors may save a file in
+// several steps, before returning every source that changed.
+func (watcher *sourceWatcher) wait(ctx context.Context, interval time.Duration) (changed []string, err error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		more := watcher.changes()
+
+		for _, path := range more {
+			if !containsString(changed, path) {
+				changed = append(changed, path)
+			}
+		}
+
+		if len(changed) > 0 && len(more) == 0 {
+			return
+		}
+	}
+}
+
+func statSource(path string) (state sourceState) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	state.exists = true
+	state.isDir = info.IsDir()
+	state.modTime = info.ModTime()
+
+	if !state.isDir {
+		state.size = info.Size()
+	}
+
+	return
+}
+
+// digestSource hashes the content of a file, or the names of the entries
+// of a directory.
+func digestSource(path string, state sourceState) string {
+	if !state.exists {
+		return ""
+	}
+
+	if b, err := os.ReadFile(path); err == nil {
+		return hash.Uint256(b).Text(62)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return ""
+	}
+
+	names := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return hash.Uint256String(strings.Join(names, "\n")).Text(62)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}